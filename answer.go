@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"linebot-grok/gemini"
+	"linebot-grok/models"
+	"linebot-grok/postback"
+	"linebot-grok/prompt"
+	"linebot-grok/provider"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"log"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const maxFollowUps = 3
+
+// LINE limits for quick reply action labels and message action text.
+const maxLabelRunes = 20
+const maxActionTextRunes = 300
+
+// maxQuickReplyItems is the most buttons LINE shows under a message.
+const maxQuickReplyItems = 13
+
+// systemPrompt is the default prompt plus the conversation's persona and language.
+func systemPrompt(ref string) string {
+	settings := st.Settings(ref)
+	return prompt.Build(settings.Persona, settings.Lang)
+}
+
+// generateAnswer answers ex.Question after the turns of history with the
+// named model, or with the conversation's chosen model when model is empty,
+// and returns ex with the answer and the model used filled in. If the
+// model's provider is down the catalog's fallback models are tried in order.
+func generateAnswer(ctx context.Context, ref string, history []store.Exchange, ex store.Exchange, model string) (store.Exchange, error) {
+	if model == "" {
+		model = st.Settings(ref).ChatModel
+	}
+	catalog := models.Get()
+	system := withSummary(systemPrompt(ref), ref)
+
+	first := catalog.Resolve(model, models.KindChat)
+	kind := ratelimit.KindChat
+	if first.Search {
+		kind = ratelimit.KindSearch
+	}
+	if err := checkLimit(ctx, kind); err != nil {
+		return ex, err
+	}
+
+	var err error
+	for i, m := range catalog.Chain(first) {
+		if i > 0 {
+			log.Printf("Falling back to %s: %v", m.Name, err)
+		}
+		var answer string
+		answer, err = askModel(ctx, m, system, history, ex)
+		if err == nil {
+			ex.Answer = answer
+			ex.Model = m.Name
+			return ex, nil
+		}
+		if !provider.Outage(err) {
+			break
+		}
+	}
+	return ex, err
+}
+
+// askModel sends the question to one model after as much of the history as
+// fits in the model's context budget.
+func askModel(ctx context.Context, m models.Model, system string, history []store.Exchange, ex store.Exchange) (string, error) {
+	if m.Provider == models.ProviderGrok {
+		return callGrokAPI(ctx, history, m, system, ex.Question)
+	}
+	turns := []gemini.Turn{}
+	for _, t := range fitTurns(history, m, system, ex.Question) {
+		turns = append(turns, gemini.Turn{Question: t.Question, Answer: t.Answer})
+	}
+	if m.Search {
+		return gemini.GenerateByGeminiWithSearch(ctx, m.ID, system, turns, ex.Question, locationContext(ex.Location))
+	}
+	return gemini.ChatByGemini(ctx, m.ID, system, turns, ex.Question)
+}
+
+// replyAnswer sends an answer with quick reply suggestions and remembers it
+// as the conversation's latest exchange.
+func replyAnswer(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, ref string, ex store.Exchange) {
+	ex.Time = time.Now()
+	st.SetLastExchange(ref, ex)
+
+	replyMsg := []messaging_api.MessageInterface{}
+	parts := splitString(ex.Answer)
+	for i, part := range parts {
+		msg := &messaging_api.TextMessage{
+			Text: part,
+		}
+		// LINE only shows the quick reply of the last message.
+		if i == len(parts)-1 {
+			msg.QuickReply = answerQuickReply(ctx, ref, ex)
+		}
+		replyMsg = append(replyMsg, msg)
+	}
+
+	_, err := bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
+		ReplyToken: replyToken,
+		Messages:   replyMsg,
+	})
+	if err != nil {
+		log.Printf("Error replying to message: %v", err)
+	}
+}
+
+// followUpTimeout bounds how long a reply waits for follow-up suggestions.
+var followUpTimeout = 2 * time.Second
+
+// answerQuickReply builds the follow-up questions suggested by the model
+// followed by the fixed regenerate, shorter, translate and rating actions.
+func answerQuickReply(ctx context.Context, ref string, ex store.Exchange) *messaging_api.QuickReply {
+	items := []messaging_api.QuickReplyItem{}
+
+	// The reply waits for the suggestions, so it only waits so long for them.
+	sctx, cancel := context.WithTimeout(ctx, followUpTimeout)
+	defer cancel()
+	suggestions, err := suggestFollowUps(sctx, ex.Question, ex.Answer)
+	switch {
+	case sctx.Err() == context.DeadlineExceeded:
+		log.Printf("Skipped follow-up suggestions: none within %s", followUpTimeout)
+		suggestions = nil
+	case err != nil:
+		log.Printf("Error suggesting follow-ups: %v", err)
+	}
+	for _, q := range suggestions {
+		if q == "" {
+			continue
+		}
+		items = append(items, messageItem(q, cfg.Bot.ChatPrefix+" "+q))
+	}
+
+	if item, ok := postbackItem("Regenerate", "Regenerate", postback.Payload{Action: "regen", Ref: ref, Args: []string{ex.ID}}); ok {
+		items = append(items, item)
+	}
+	if item, ok := postbackItem("Edit", "", postback.Payload{Action: "edit", Ref: ref}); ok {
+		action := item.Action.(*messaging_api.PostbackAction)
+		action.InputOption = messaging_api.PostbackActionINPUT_OPTION_OPEN_KEYBOARD
+		action.FillInText = truncateRunes(command("edit "+ex.ID+" "+ex.Question), maxActionTextRunes)
+		items = append(items, item)
+	}
+	// Offer to ask a chat model from another provider.
+	for _, name := range models.Get().Names(models.KindChat) {
+		m, _ := models.Get().Lookup(name)
+		if current, ok := models.Get().Lookup(ex.Model); ok && m.Provider == current.Provider {
+			continue
+		}
+		if item, ok := postbackItem("Ask "+m.Name, "Ask "+m.Name, postback.Payload{Action: "regen", Ref: ref, Args: []string{ex.ID, m.Name}}); ok {
+			items = append(items, item)
+		}
+		break
+	}
+	items = append(items,
+		messageItem("Shorter", command("shorter")),
+		messageItem("Translate", command("translate")),
+	)
+	for _, r := range []struct{ label, arg string }{{"👍", "up"}, {"👎", "down"}} {
+		if item, ok := postbackItem(r.label, r.label, postback.Payload{Action: "rate", Ref: ref, Args: []string{r.arg, ex.ID}}); ok {
+			items = append(items, item)
+		}
+	}
+	return &messaging_api.QuickReply{Items: items}
+}
+
+func messageItem(label string, text string) messaging_api.QuickReplyItem {
+	return messaging_api.QuickReplyItem{
+		Type: "action",
+		Action: &messaging_api.MessageAction{
+			Label: truncateRunes(label, maxLabelRunes),
+			Text:  truncateRunes(text, maxActionTextRunes),
+		},
+	}
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of API keys, one per HTTP chat route.
+const (
+	scopeGrok   = "grok"
+	scopeGemini = "gemini"
+)
+
+var apiScopes = []string{scopeGrok, scopeGemini}
+
+// keyPrefix starts every API key, so leaked keys are easy to recognise.
+const keyPrefix = "lbk_"
+
+// newAPIKey returns a new key and its public ID, which is part of the key.
+func newAPIKey() (id string, key string) {
+	id = hex.EncodeToString(randomBytes(4))
+	return id, keyPrefix + id + "_" + hex.EncodeToString(randomBytes(24))
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// keyPolicy is the default limit of kind with the key's own quota applied.
+func keyPolicy(k store.APIKey, kind ratelimit.Kind) ratelimit.Policy {
+	p := limiter.Policy(kind)
+	if k.PerMinute > 0 {
+		p.PerMinute, p.Burst = k.PerMinute, 0
+	}
+	if k.Daily > 0 {
+		p.Daily = k.Daily
+	}
+	return p
+}
+
+// apiRoute requires a bearer API key with scope and rate limits the route
+// per key, answering 401, 403 or 429 when the request isn't allowed.
+func apiRoute(scope string, kind ratelimit.Kind, next http.HandlerFunc) http.HandlerFunc {
+	return cors(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing API key", http.StatusUnauthorized)
+			return
+		}
+		k, ok := st.APIKeyFor(strings.TrimSpace(token))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if !k.Allows(scope) {
+			http.Error(w, fmt.Sprintf("API key is not allowed to use %s", scope), http.StatusForbidden)
+			return
+		}
+		c := caller{Subject: "key:" + k.ID, Command: r.URL.Path}
+		if err := limiter.AllowPolicy(kind, c.Subject, keyPolicy(k, kind)); err != nil {
+			if le, ok := err.(*ratelimit.Error); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(le.RetryAfter.Round(time.Second).Seconds())))
+			}
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		st.UseAPIKey(k.ID, string(kind), limiter.Today())
+		next(w, r.WithContext(withCaller(r.Context(), c)))
+	})
+}
+
+// restoreKeyQuotas carries the requests API keys made today over to the
+// limiter, so a restart doesn't reset their daily quotas.
+func restoreKeyQuotas() {
+	today := limiter.Today()
+	for _, k := range st.APIKeys() {
+		if k.Day != today {
+			continue
+		}
+		for kind, used := range k.Used {
+			limiter.Restore(ratelimit.Kind(kind), "key:"+k.ID, keyPolicy(k, ratelimit.Kind(kind)), used)
+		}
+	}
+}
+
+// flushKeyUse saves the use of API keys since it was last saved.
+func flushKeyUse() {
+	if err := st.FlushAPIKeys(); err != nil {
+		log.Printf("Error saving API key use: %v", err)
+	}
+}
+
+// isAdmin reports whether userID may run admin commands.
+func isAdmin(userID string) bool {
+	return userID != "" && slices.ContainsFunc(cfg.Bot.Admins, func(a string) bool {
+		return strings.TrimSpace(a) == userID
+	})
+}
+
+// keyCommand handles "AI key new|list|revoke" for admins. Keys are only
+// shown in one-to-one chats so they don't leak into groups.
+func keyCommand(ref string, userID string, args []string) string {
+	if !isAdmin(userID) {
+		return "Only bot admins can manage API keys."
+	}
+	usage := "Usage: " + command("key new <name> [grok] [gemini] [daily=N] [perMinute=N]") + " | " + command("key list") + " | " + command("key revoke <id>")
+	if len(args) == 0 {
+		return usage
+	}
+	switch strings.ToLower(args[0]) {
+	case "new":
+		if !strings.HasPrefix(ref, "user:") {
+			return "Issue API keys in a one-to-one chat with me, so the key isn't shown to the group."
+		}
+		if len(args) < 2 {
+			return usage
+		}
+		k := store.APIKey{Name: args[1], CreatedBy: userID, Created: time.Now()}
+		for _, a := range args[2:] {
+			name, value, _ := strings.Cut(strings.ToLower(a), "=")
+			var err error
+			switch {
+			case slices.Contains(apiScopes, name) && value == "":
+				if !k.Allows(name) {
+					k.Scopes = append(k.Scopes, name)
+				}
+			case name == "daily":
+				k.Daily, err = strconv.Atoi(value)
+			case name == "perminute":
+				k.PerMinute, err = strconv.ParseFloat(value, 64)
+			default:
+				return usage
+			}
+			if err != nil || k.Daily < 0 || k.PerMinute < 0 {
+				return usage
+			}
+		}
+		if len(k.Scopes) == 0 {
+			k.Scopes = slices.Clone(apiScopes)
+		}
+		id, key := newAPIKey()
+		k.ID, k.Hash = id, store.HashKey(key)
+		if err := st.AddAPIKey(k); err != nil {
+			log.Printf("Error saving API key: %v", err)
+			return "Sorry, I couldn't save the key. Please try again."
+		}
+		return fmt.Sprintf("Issued key %s (%s) for %s:\n%s\nSend it as \"Authorization: Bearer <key>\". It won't be shown again.", k.ID, k.Name, strings.Join(k.Scopes, ", "), key)
+	case "list":
+		keys := st.APIKeys()
+		if len(keys) == 0 {
+			return "No API keys have been issued."
+		}
+		var sb strings.Builder
+		for _, k := range keys {
+			sb.WriteString(fmt.Sprintf("%s %s - %s", k.ID, k.Name, strings.Join(k.Scopes, ", ")))
+			if k.Daily > 0 {
+				sb.WriteString(fmt.Sprintf(", %d/day", k.Daily))
+			}
+			if k.PerMinute > 0 {
+				sb.WriteString(fmt.Sprintf(", %g/min", k.PerMinute))
+			}
+			if !k.LastUsed.IsZero() {
+				sb.WriteString(", last used " + k.LastUsed.In(usageZone).Format("2006-01-02 15:04"))
+			}
+			sb.WriteString("\n")
+		}
+		return strings.TrimSpace(sb.String())
+	case "revoke":
+		if len(args) < 2 {
+			return usage
+		}
+		found, err := st.RevokeAPIKey(args[1])
+		if err != nil {
+			log.Printf("Error revoking API key: %v", err)
+			return "Sorry, I couldn't revoke the key. Please try again."
+		}
+		if !found {
+			return fmt.Sprintf("There is no key %q.", args[1])
+		}
+		return fmt.Sprintf("Revoked key %s.", args[1])
+	}
+	return usage
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"linebot-grok/gemini"
+	"linebot-grok/models"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// callbackHandler handles the LINE webhook. Events are answered through bot.
+func callbackHandler(bot *messaging_api.MessagingApiAPI, channelSecret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse incoming LINE webhook request
+		cb, err := webhook.ParseRequest(channelSecret, r)
+		if err != nil {
+			if errors.Is(err, webhook.ErrInvalidSignature) {
+				w.WriteHeader(400)
+			} else {
+				w.WriteHeader(500)
+			}
+			log.Printf("Error parsing request: %v", err)
+			return
+		}
+		ctx := r.Context()
+		// Process each event
+		for _, event := range cb.Events {
+			switch e := event.(type) {
+			case webhook.MessageEvent:
+				ctx := withCaller(ctx, lineCaller(e.Source))
+				switch msg := e.Message.(type) {
+				case webhook.TextMessageContent:
+					thisText := strings.TrimSpace(msg.Text)
+					if strings.HasPrefix(strings.ToLower(thisText), strings.ToLower(cfg.Bot.CommandPrefix)) {
+						if handleCommand(ctx, bot, e, thisText) {
+							continue
+						}
+					}
+					recordGroupMessage(bot, e.Source, thisText)
+					// Check if message starts with "AI@"
+					if strings.HasPrefix(strings.ToLower(thisText), strings.ToLower(cfg.Bot.ChatPrefix)) {
+						// Extract the message content after "AI@"
+						userMsg := strings.TrimSpace(strings.TrimPrefix(strings.ToLower(thisText), strings.ToLower(cfg.Bot.ChatPrefix)))
+						if userMsg == "" {
+							continue // Skip if no content after "AI@"
+						}
+
+						// The request comes from LINE's servers, so its IP says
+						// nothing about the user; use the location they shared.
+						ref, userID := conversationRef(e.Source)
+						ctx := withCommand(ctx, "chat")
+						ex, err := answerTurn(ctx, ref, contextTurns(ref), store.Exchange{
+							Question: userMsg,
+							UserID:   userID,
+							Location: userLocation(userID),
+						}, "")
+						if err != nil {
+							log.Printf("Error generating answer: %v", err)
+							replyText(bot, e.ReplyToken, friendlyError(ref, err))
+							continue
+						}
+						replyAnswer(ctx, bot, e.ReplyToken, ref, ex)
+					} else if strings.HasPrefix(strings.ToLower(thisText), strings.ToLower(cfg.Bot.ImagePrefix)) {
+						// Extract the message content after "AI@"
+						grokMsg := strings.TrimSpace(strings.TrimPrefix(strings.ToLower(thisText), strings.ToLower(cfg.Bot.ImagePrefix)))
+						if grokMsg == "" {
+							continue // Skip if no content after "AI@"
+						}
+						ref, userID := conversationRef(e.Source)
+						ctx := withCommand(ctx, "image")
+						if err := checkLimit(ctx, ratelimit.KindImage); err != nil {
+							replyText(bot, e.ReplyToken, friendlyError(ref, err))
+							continue
+						}
+						imageModel := models.Get().Resolve(st.Settings(ref).ImageModel, models.KindImage)
+						var response string
+						if grokMsg[0] == '#' {
+							grokMsg = grokMsg[1:] // remove the first character
+							// "AI##" always draws with Grok
+							if m, ok := models.Get().First(models.ProviderGrok, models.KindImage); ok {
+								imageModel = m
+							}
+						}
+						if imageModel.Provider == models.ProviderGrok {
+							// Call Grok API
+							response, err = generateImageByGrok(ctx, imageModel.ID, grokMsg)
+							if err != nil {
+								log.Printf("Error calling Grok API: %v", err)
+								replyText(bot, e.ReplyToken, friendlyError(ref, err))
+								continue
+							}
+						} else {
+							// Call Gemini API
+							imgData, err := gemini.GenerateImageByGemini(ctx, imageModel.ID, grokMsg)
+							if err != nil {
+								log.Printf("Error calling Gemini API: %v", err)
+								replyText(bot, e.ReplyToken, friendlyError(ref, err))
+								continue
+							}
+							response, err = imageURL(st.SaveImage(imgData, imageRefs(ref, userID)...))
+							if err != nil {
+								log.Printf("Error linking image: %v", err)
+								replyText(bot, e.ReplyToken, friendlyError(ref, err))
+								continue
+							}
+						}
+
+						// Reply to the user via LINE
+						_, err = bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
+							ReplyToken: e.ReplyToken,
+							Messages: []messaging_api.MessageInterface{
+								&messaging_api.ImageMessage{
+									OriginalContentUrl: response,
+									PreviewImageUrl:    response,
+								},
+							},
+						})
+						if err != nil {
+							log.Printf("Error replying to message: %v", err)
+						}
+					}
+				case webhook.LocationMessageContent:
+					locationMessage(bot, e, msg)
+				}
+			case webhook.PostbackEvent:
+				handlePostback(withCaller(ctx, lineCaller(e.Source)), bot, e)
+			case webhook.FollowEvent:
+				sendWelcome(bot, e.ReplyToken)
+			case webhook.JoinEvent:
+				sendWelcome(bot, e.ReplyToken)
+			case webhook.UnfollowEvent:
+				purgeConversation(e.Source)
+			case webhook.LeaveEvent:
+				purgeConversation(e.Source)
+			}
+		}
+	}
+}
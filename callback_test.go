@@ -0,0 +1,330 @@
+package main
+
+import (
+	"linebot-grok/linetest"
+	"linebot-grok/mockprovider"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postbackData returns the data of the quick reply button labelled label.
+func postbackData(t *testing.T, reply linetest.Reply, label string) string {
+	t.Helper()
+	for _, m := range reply.Messages {
+		qr, _ := m["quickReply"].(map[string]any)
+		items, _ := qr["items"].([]any)
+		for _, item := range items {
+			action, _ := item.(map[string]any)["action"].(map[string]any)
+			if action["label"] == label && action["type"] == "postback" {
+				data, _ := action["data"].(string)
+				return data
+			}
+		}
+	}
+	t.Fatalf("no %q postback button in %v", label, reply.Messages)
+	return ""
+}
+
+func TestCallbackEvents(t *testing.T) {
+	user := linetest.User("U1")
+	group := linetest.Group("C1", "U1")
+
+	for _, tc := range []struct {
+		name string
+		// before are sent first; the reply to the last one is passed to event.
+		before []linetest.Event
+		event  func(t *testing.T, prev linetest.Reply) linetest.Event
+		check  func(t *testing.T, b *testBot, reply linetest.Reply, replied bool)
+	}{
+		{
+			name:  "chat",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event { return linetest.Text(user, "AI@ what is LINE?") },
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				texts := reply.Texts()
+				if len(texts) != 1 {
+					t.Fatalf("reply = %q", texts)
+				}
+				if !strings.HasPrefix(texts[0], "mock reply: what is line?\n") || !strings.Contains(texts[0], "\n[Sources]\n") {
+					t.Errorf("reply = %q", texts[0])
+				}
+				labels := strings.Join(reply.Messages[0].QuickReplyLabels(), ",")
+				for _, want := range []string{"Regenerate", "Shorter", "👍", "👎"} {
+					if !strings.Contains(labels, want) {
+						t.Errorf("buttons %s lack %s", labels, want)
+					}
+				}
+				if ex, ok := st.LastExchange("user:U1"); !ok || ex.Question != "what is line?" {
+					t.Errorf("last exchange = %+v, %v", ex, ok)
+				}
+			},
+		},
+		{
+			name:  "chat prefix without a question",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event { return linetest.Text(user, "AI@   ") },
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if replied || len(b.mock.Requests()) != 0 {
+					t.Error("an empty question was answered")
+				}
+			},
+		},
+		{
+			name:  "other text",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event { return linetest.Text(group, "lunch at noon?") },
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if replied {
+					t.Errorf("replied %q to a plain message", reply.Texts())
+				}
+			},
+		},
+		{
+			name: "image",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event {
+				return linetest.Text(group, "AI# a cat on the moon")
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if len(reply.Messages) != 1 || reply.Messages[0].Type() != "image" {
+					t.Fatalf("reply = %v", reply.Messages)
+				}
+				url, _ := reply.Messages[0]["originalContentUrl"].(string)
+				if !strings.HasPrefix(url, "https://bot.example.com/img/") {
+					t.Errorf("image URL = %q", url)
+				}
+			},
+		},
+		{
+			name: "image with Grok",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event {
+				return linetest.Text(group, "AI## a cat on the moon")
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if len(reply.Messages) != 1 || reply.Messages[0].Type() != "image" {
+					t.Fatalf("reply = %v", reply.Messages)
+				}
+				if url, _ := reply.Messages[0]["originalContentUrl"].(string); url != b.mock.URL+"/image.png" {
+					t.Errorf("image URL = %q, want Grok's", url)
+				}
+			},
+		},
+		{
+			name: "location",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event {
+				return linetest.Location(group, "Office", "1 Main St", 25.0330, 121.5654)
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if texts := reply.Texts(); len(texts) != 1 || !strings.HasPrefix(texts[0], "Got it, I'll use ") {
+					t.Errorf("reply = %q", texts)
+				}
+				if loc, ok := st.Location("user:U1"); !ok || loc.Address != "1 Main St" {
+					t.Errorf("location = %+v, %v", loc, ok)
+				}
+			},
+		},
+		{
+			name:   "rate postback",
+			before: []linetest.Event{linetest.Text(user, "AI@ hello")},
+			event: func(t *testing.T, prev linetest.Reply) linetest.Event {
+				return linetest.Postback(user, postbackData(t, prev, "👍"))
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if texts := reply.Texts(); len(texts) != 1 || texts[0] != "Thanks for the feedback!" {
+					t.Errorf("reply = %q", texts)
+				}
+				if ex, _ := st.LastExchange("user:U1"); ex.Rating != 1 {
+					t.Errorf("rating = %d, want 1", ex.Rating)
+				}
+			},
+		},
+		{
+			name:   "regenerate postback",
+			before: []linetest.Event{linetest.Text(user, "AI@ hello")},
+			event: func(t *testing.T, prev linetest.Reply) linetest.Event {
+				return linetest.Postback(user, postbackData(t, prev, "Ask grok"))
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if texts := reply.Texts(); len(texts) != 1 || texts[0] != "mock reply: hello" {
+					t.Errorf("reply = %q", texts)
+				}
+				if ex, _ := st.LastExchange("user:U1"); ex.Model != "grok" {
+					t.Errorf("answered by %q, want grok", ex.Model)
+				}
+			},
+		},
+		{
+			name:   "model postback",
+			before: []linetest.Event{linetest.Text(group, "AI model")},
+			event: func(t *testing.T, prev linetest.Reply) linetest.Event {
+				return linetest.Postback(group, postbackData(t, prev, "grok"))
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if texts := reply.Texts(); len(texts) != 1 || texts[0] != "Switched chat model to grok." {
+					t.Errorf("reply = %q", texts)
+				}
+				if m := st.Settings("group:C1").ChatModel; m != "grok" {
+					t.Errorf("chat model = %q", m)
+				}
+			},
+		},
+		{
+			name:   "postback from another conversation",
+			before: []linetest.Event{linetest.Text(user, "AI@ hello")},
+			event: func(t *testing.T, prev linetest.Reply) linetest.Event {
+				return linetest.Postback(group, postbackData(t, prev, "👎"))
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if replied {
+					t.Errorf("replied %q to a postback issued elsewhere", reply.Texts())
+				}
+				if ex, _ := st.LastExchange("user:U1"); ex.Rating != 0 {
+					t.Errorf("rating = %d, want none", ex.Rating)
+				}
+			},
+		},
+		{
+			name: "forged postback",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event {
+				return linetest.Postback(user, "v1|model|user:U1|grok|forged")
+			},
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if replied || st.Settings("user:U1").ChatModel != "" {
+					t.Error("a forged postback was acted on")
+				}
+			},
+		},
+		{
+			name:  "follow",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event { return linetest.Follow(user) },
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if texts := reply.Texts(); len(texts) != 1 || texts[0] != welcomeMessage() {
+					t.Errorf("reply = %q, want the welcome message", texts)
+				}
+				if labels := reply.Messages[0].QuickReplyLabels(); len(labels) != 3 {
+					t.Errorf("buttons = %q", labels)
+				}
+			},
+		},
+		{
+			name:  "join",
+			event: func(t *testing.T, _ linetest.Reply) linetest.Event { return linetest.Join(group) },
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				if texts := reply.Texts(); len(texts) != 1 || texts[0] != welcomeMessage() {
+					t.Errorf("reply = %q, want the welcome message", texts)
+				}
+			},
+		},
+		{
+			name:   "unfollow",
+			before: []linetest.Event{linetest.Text(user, "AI@ hello")},
+			event:  func(t *testing.T, _ linetest.Reply) linetest.Event { return linetest.Unfollow(user) },
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				checkPurged(t, "user:U1", replied)
+			},
+		},
+		{
+			name:   "leave",
+			before: []linetest.Event{linetest.Text(group, "AI@ hello")},
+			event:  func(t *testing.T, _ linetest.Reply) linetest.Event { return linetest.Leave(group) },
+			check: func(t *testing.T, b *testBot, reply linetest.Reply, replied bool) {
+				checkPurged(t, "group:C1", replied)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestBot(t)
+			var prev linetest.Reply
+			for _, ev := range tc.before {
+				prev = b.send(t, ev)
+			}
+			b.mock.Reset()
+			ev := tc.event(t, prev)
+			reply := b.send(t, ev)
+			_, replied := b.line.ReplyTo(ev.ReplyToken())
+			tc.check(t, b, reply, replied)
+		})
+	}
+}
+
+func TestCallbackSkipsSlowFollowUps(t *testing.T) {
+	b := newTestBot(t)
+	defer func(d time.Duration) { followUpTimeout = d }(followUpTimeout)
+	followUpTimeout = 50 * time.Millisecond
+	// The answer comes first, then the suggestions, which take too long.
+	b.mock.Fail(
+		mockprovider.Failure{Provider: "gemini"},
+		mockprovider.Failure{Provider: "gemini", Delay: 5 * time.Second},
+	)
+
+	start := time.Now()
+	reply := b.send(t, linetest.Text(linetest.User("U1"), "AI@ hello"))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("reply took %s", elapsed)
+	}
+	if texts := reply.Texts(); len(texts) != 1 || !strings.HasPrefix(texts[0], "mock reply: hello") {
+		t.Fatalf("reply = %q", texts)
+	}
+	if labels := reply.Messages[0].QuickReplyLabels(); len(labels) == 0 || labels[0] != "Regenerate" {
+		t.Errorf("buttons = %q, want no suggestions before Regenerate", labels)
+	}
+}
+
+func TestForgetMeDeletesExports(t *testing.T) {
+	b := newTestBot(t)
+	user := linetest.User("U1")
+	group := linetest.Group("C1", "U1")
+	other := linetest.Group("C2", "U2")
+
+	// export asks for an export of the chat src is in and returns its path.
+	export := func(src linetest.Source) string {
+		t.Helper()
+		texts := b.send(t, linetest.Text(src, command("export"))).Texts()
+		if len(texts) != 1 || !strings.Contains(texts[0], "https://bot.example.com/export/") {
+			t.Fatalf("export reply = %q", texts)
+		}
+		return "/export/" + texts[0][strings.LastIndex(texts[0], "/")+1:]
+	}
+	download := func(path string) int {
+		rec := httptest.NewRecorder()
+		b.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	b.send(t, linetest.Text(user, "AI@ hello"))
+	b.send(t, linetest.Text(group, "AI@ hello from the group"))
+	b.send(t, linetest.Text(other, "AI@ hello from elsewhere"))
+	own, shared, unrelated := export(user), export(group), export(other)
+	for _, path := range []string{own, shared, unrelated} {
+		if code := download(path); code != http.StatusOK {
+			t.Fatalf("GET %s = %d before forget me", path, code)
+		}
+	}
+
+	confirm := b.send(t, linetest.Text(group, command("forget me")))
+	reply := b.send(t, linetest.Postback(group, postbackData(t, confirm, "Forget me")))
+	if texts := reply.Texts(); len(texts) != 1 || texts[0] != "Everything I stored about you has been deleted." {
+		t.Fatalf("reply = %q", texts)
+	}
+	for _, path := range []string{own, shared} {
+		if code := download(path); code != http.StatusNotFound {
+			t.Errorf("GET %s = %d after forget me, want 404", path, code)
+		}
+	}
+	if code := download(unrelated); code != http.StatusOK {
+		t.Errorf("GET %s = %d, want another chat's export kept", unrelated, code)
+	}
+}
+
+// checkPurged checks that nothing is kept of ref after the bot was removed.
+func checkPurged(t *testing.T, ref string, replied bool) {
+	t.Helper()
+	if replied {
+		t.Error("replied to an event without a reply token")
+	}
+	if _, ok := st.LastExchange(ref); ok {
+		t.Error("the last exchange was kept")
+	}
+	if _, ok := c.Get(ref); ok {
+		t.Error("the cached turns were kept")
+	}
+}
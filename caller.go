@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"linebot-grok/ratelimit"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+var limiter = ratelimit.New(nil, nil)
+
+type callerKey struct{}
+
+// caller is who a request is made for; limits are counted per Subject.
+type caller struct {
+	// Subject is "line:<user ID>", or the conversation ref when LINE didn't
+	// send a user ID, or "key:<key ID>" for the HTTP routes.
+	Subject string
+	UserID  string
+	Ref     string
+	// Command is what the request is for, e.g. "chat", "image", "sum" or
+	// "/grok/chat", so usage can be broken down by it.
+	Command string
+}
+
+func withCaller(ctx context.Context, c caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+func callerFrom(ctx context.Context) caller {
+	c, _ := ctx.Value(callerKey{}).(caller)
+	return c
+}
+
+// withCommand records what the caller in ctx asked for.
+func withCommand(ctx context.Context, cmd string) context.Context {
+	c := callerFrom(ctx)
+	c.Command = cmd
+	return withCaller(ctx, c)
+}
+
+// lineCaller identifies the sender of a webhook event.
+func lineCaller(src webhook.SourceInterface) caller {
+	ref, userID := conversationRef(src)
+	subject := ref
+	if userID != "" {
+		subject = "line:" + userID
+	}
+	return caller{Subject: subject, UserID: userID, Ref: ref}
+}
+
+// checkLimit takes one request of kind from the caller's limits. Requests
+// without a caller, such as internal suggestions, aren't limited.
+func checkLimit(ctx context.Context, kind ratelimit.Kind) error {
+	c := callerFrom(ctx)
+	if c.Subject == "" {
+		return nil
+	}
+	return limiter.Allow(kind, c.Subject)
+}
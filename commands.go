@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"linebot-grok/models"
+	"linebot-grok/postback"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+	"github.com/patrickmn/go-cache"
+)
+
+var st = store.New(store.Options{})
+var nameCache = cache.New(60*time.Minute, 60*time.Minute)
+
+const defaultSumLines = 50
+
+// command renders a bot command with the configured prefix, e.g. "AI log on".
+func command(s string) string {
+	return cfg.Bot.CommandPrefix + s
+}
+
+// conversationRef returns the store key for the chat the event came from
+// and the ID of the user who sent it.
+func conversationRef(src webhook.SourceInterface) (string, string) {
+	switch s := src.(type) {
+	case webhook.GroupSource:
+		return "group:" + s.GroupId, s.UserId
+	case webhook.RoomSource:
+		return "room:" + s.RoomId, s.UserId
+	case webhook.UserSource:
+		return "user:" + s.UserId, s.UserId
+	}
+	return "", ""
+}
+
+// displayName looks up the sender's LINE display name, falling back to the user ID.
+func displayName(bot *messaging_api.MessagingApiAPI, src webhook.SourceInterface, userID string) string {
+	if userID == "" {
+		return "someone"
+	}
+	if name, found := nameCache.Get(userID); found {
+		return name.(string)
+	}
+	name := userID
+	switch s := src.(type) {
+	case webhook.GroupSource:
+		if p, err := bot.GetGroupMemberProfile(s.GroupId, userID); err == nil {
+			name = p.DisplayName
+		}
+	case webhook.RoomSource:
+		if p, err := bot.GetRoomMemberProfile(s.RoomId, userID); err == nil {
+			name = p.DisplayName
+		}
+	default:
+		if p, err := bot.GetProfile(userID); err == nil {
+			name = p.DisplayName
+		}
+	}
+	nameCache.SetDefault(userID, name)
+	return name
+}
+
+// recordGroupMessage stores a group/room text message if that chat opted in to logging.
+func recordGroupMessage(bot *messaging_api.MessagingApiAPI, src webhook.SourceInterface, text string) {
+	ref, userID := conversationRef(src)
+	if strings.HasPrefix(ref, "user:") || !st.GroupLogEnabled(ref) {
+		return
+	}
+	st.AppendGroupLog(ref, store.ChatLine{
+		UserID: userID,
+		Name:   displayName(bot, src, userID),
+		Text:   text,
+		Time:   time.Now(),
+	})
+}
+
+// handleCommand runs an "AI <command>" message. It returns false if the text is not a known command.
+func handleCommand(ctx context.Context, bot *messaging_api.MessagingApiAPI, e webhook.MessageEvent, text string) bool {
+	args := strings.Fields(strings.TrimSpace(text[len(cfg.Bot.CommandPrefix):]))
+	if len(args) == 0 {
+		return false
+	}
+	ref, userID := conversationRef(e.Source)
+	ctx = withCommand(ctx, strings.ToLower(args[0]))
+
+	var reply string
+	switch strings.ToLower(args[0]) {
+	case "log":
+		reply = logCommand(ref, args[1:])
+	case "sum":
+		reply = sumCommand(ctx, ref, args[1:])
+	case "set":
+		reply = setCommand(ref, userID, text)
+	case "usage":
+		reply = usageCommand(ctx, ref)
+	case "key":
+		reply = keyCommand(ref, userID, args[1:])
+	case "reset":
+		resetCommand(bot, e.ReplyToken, ref, userID)
+		return true
+	case "export":
+		reply = exportCommand(ref, userID)
+	case "forget":
+		if len(args) < 2 || strings.ToLower(args[1]) != "me" {
+			return false
+		}
+		forgetMeCommand(bot, e.ReplyToken, ref, userID)
+		return true
+	case "model":
+		if len(args) < 2 {
+			replyModels(bot, e.ReplyToken, ref)
+			return true
+		}
+		reply = selectModel(ref, args[1])
+	case "regen", "shorter", "translate":
+		ex, ok := st.LastExchange(ref)
+		if !ok {
+			reply = "There is no previous answer in this chat."
+			break
+		}
+		ex, err := followUpCommand(ctx, ref, strings.ToLower(args[0]), ex, args[1:])
+		if err != nil {
+			log.Printf("Error running %q: %v", args[0], err)
+			reply = turnError(ref, err)
+			break
+		}
+		replyAnswer(ctx, bot, e.ReplyToken, ref, ex)
+		return true
+	case "history":
+		reply = historyCommand(ref)
+	case "edit":
+		// Keep the original spacing and case of the question.
+		rest := strings.TrimSpace(strings.TrimSpace(text[len(cfg.Bot.CommandPrefix):])[len("edit"):])
+		id, question, _ := strings.Cut(rest, " ")
+		if question = strings.TrimSpace(question); question == "" {
+			reply = "Usage: " + command("edit <id> <question>")
+			break
+		}
+		ex, err := editTurn(withCommand(ctx, "chat"), ref, id, store.Exchange{
+			Question: question,
+			UserID:   userID,
+			Location: userLocation(userID),
+		})
+		if err != nil {
+			log.Printf("Error editing %s: %v", id, err)
+			reply = turnError(ref, err)
+			break
+		}
+		replyAnswer(ctx, bot, e.ReplyToken, ref, ex)
+		return true
+	default:
+		return false
+	}
+
+	replyText(bot, e.ReplyToken, reply)
+	return true
+}
+
+// logCommand handles "AI log on|off".
+func logCommand(ref string, args []string) string {
+	if strings.HasPrefix(ref, "user:") {
+		return "Message logging is only available in group chats."
+	}
+	if len(args) == 0 {
+		if st.GroupLogEnabled(ref) {
+			return fmt.Sprintf("Message logging is on. Use %q to stop and clear it.", command("log off"))
+		}
+		return fmt.Sprintf("Message logging is off. Use %q to enable %q.", command("log on"), command("sum"))
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		st.EnableGroupLog(ref, true)
+		return fmt.Sprintf("Message logging enabled. I keep up to %d messages from the last %s.", st.MaxLogMessages, st.MaxLogAge)
+	case "off":
+		st.EnableGroupLog(ref, false)
+		return "Message logging disabled and the log was cleared."
+	}
+	return "Usage: " + command("log on|off")
+}
+
+// sumCommand handles "AI sum [N|since 2h]".
+func sumCommand(ctx context.Context, ref string, args []string) string {
+	if !st.GroupLogEnabled(ref) {
+		return fmt.Sprintf("Message logging is off in this chat. Use %q first.", command("log on"))
+	}
+
+	n := defaultSumLines
+	var since time.Time
+	if len(args) > 0 {
+		if strings.ToLower(args[0]) == "since" && len(args) > 1 {
+			d, err := time.ParseDuration(args[1])
+			if err != nil || d <= 0 {
+				return "Usage: " + command("sum [N|since 2h]")
+			}
+			n = 0
+			since = time.Now().Add(-d)
+		} else if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
+			n = v
+		} else {
+			return "Usage: " + command("sum [N|since 2h]")
+		}
+	}
+
+	lines := st.RecentGroupLog(ref, n, since)
+	if len(lines) == 0 {
+		return "There is nothing to summarize yet."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Summarize the following group chat discussion. Mention who said what and list any decisions or open questions. Reply in the language used in the chat.\n\n")
+	for _, l := range lines {
+		sb.WriteString(fmt.Sprintf("[%s] %s: %s\n", l.Time.Format("15:04"), l.Name, l.Text))
+	}
+
+	if err := checkLimit(ctx, ratelimit.KindChat); err != nil {
+		return friendlyError(ref, err)
+	}
+	summary, err := askUtility(ctx, systemPrompt(ref), sb.String(), maxSumTokens)
+	if err != nil {
+		log.Printf("Error summarizing group chat: %v", err)
+		return friendlyError(ref, err)
+	}
+	return summary
+}
+
+// setCommand handles "AI set persona <text>", "AI set lang <language>" and "AI set location <city>".
+// Leaving the value empty clears the setting.
+func setCommand(ref string, userID string, text string) string {
+	// Keep the original spacing of the persona text.
+	rest := strings.TrimSpace(text[len(cfg.Bot.CommandPrefix):])
+	rest = strings.TrimSpace(rest[len("set"):])
+	key, value, _ := strings.Cut(rest, " ")
+	value = strings.TrimSpace(value)
+
+	switch strings.ToLower(key) {
+	case "persona":
+		st.UpdateSettings(ref, func(s *store.Settings) {
+			s.Persona = value
+		})
+		if value == "" {
+			return "Persona cleared."
+		}
+		return "Persona updated."
+	case "lang":
+		st.UpdateSettings(ref, func(s *store.Settings) {
+			s.Lang = value
+		})
+		if value == "" {
+			return "Language preference cleared."
+		}
+		return fmt.Sprintf("I will reply in %s.", value)
+	case "location":
+		return setLocation(userID, value)
+	}
+	return "Usage: " + command("set persona <text>") + " | " + command("set lang <language>") + " | " + command("set location <city>|off")
+}
+
+// modelList shows the catalog with the conversation's current choices.
+func modelList(ref string) string {
+	catalog := models.Get()
+	settings := st.Settings(ref)
+	var sb strings.Builder
+	for _, kind := range []string{models.KindChat, models.KindImage} {
+		selected := settings.ChatModel
+		if kind == models.KindImage {
+			selected = settings.ImageModel
+		}
+		current := catalog.Resolve(selected, kind)
+		sb.WriteString(fmt.Sprintf("%s models:\n", strings.ToUpper(kind[:1])+kind[1:]))
+		for _, name := range catalog.Names(kind) {
+			m, _ := catalog.Lookup(name)
+			mark := "  "
+			if m.Name == current.Name {
+				mark = "* "
+			}
+			sb.WriteString(fmt.Sprintf("%s%s - %s\n", mark, m.Name, m.Description))
+		}
+	}
+	sb.WriteString(fmt.Sprintf("Tap a model or use %q to switch.", command("model <name>")))
+	return sb.String()
+}
+
+// replyModels sends the model list with a button to switch to each model
+// not in use.
+func replyModels(bot *messaging_api.MessagingApiAPI, replyToken string, ref string) {
+	catalog := models.Get()
+	settings := st.Settings(ref)
+	items := []messaging_api.QuickReplyItem{}
+	for _, kind := range []string{models.KindChat, models.KindImage} {
+		selected := settings.ChatModel
+		if kind == models.KindImage {
+			selected = settings.ImageModel
+		}
+		current := catalog.Resolve(selected, kind)
+		for _, name := range catalog.Names(kind) {
+			if name == current.Name || len(items) == maxQuickReplyItems {
+				continue
+			}
+			if item, ok := postbackItem(name, "Use "+name, postback.Payload{Action: "model", Ref: ref, Args: []string{name}}); ok {
+				items = append(items, item)
+			}
+		}
+	}
+
+	msg := &messaging_api.TextMessage{Text: modelList(ref)}
+	if len(items) > 0 {
+		msg.QuickReply = &messaging_api.QuickReply{Items: items}
+	}
+	_, err := bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
+		ReplyToken: replyToken,
+		Messages:   []messaging_api.MessageInterface{msg},
+	})
+	if err != nil {
+		log.Printf("Error replying to message: %v", err)
+	}
+}
+
+// selectModel stores a chat or image model choice for the conversation.
+func selectModel(ref string, name string) string {
+	m, ok := models.Get().Lookup(name)
+	if !ok {
+		return fmt.Sprintf("Unknown model %q. Use %q to list the available models.", name, command("model"))
+	}
+	st.UpdateSettings(ref, func(s *store.Settings) {
+		if m.Kind == models.KindImage {
+			s.ImageModel = m.Name
+		} else {
+			s.ChatModel = m.Name
+		}
+	})
+	return fmt.Sprintf("Switched %s model to %s.", m.Kind, m.Name)
+}
+
+// followUpCommand reworks the latest answer: "regen" asks the question
+// again, "shorter" condenses the answer and "translate [language]" translates it.
+func followUpCommand(ctx context.Context, ref string, cmd string, ex store.Exchange, args []string) (store.Exchange, error) {
+	if cmd == "regen" {
+		return regenerateTurn(ctx, ref, ex.ID, "")
+	}
+
+	if err := checkLimit(ctx, ratelimit.KindChat); err != nil {
+		return ex, err
+	}
+	var answer string
+	var err error
+	switch cmd {
+	case "shorter":
+		answer, err = askUtility(ctx, "", "Rewrite the following answer to be much shorter, keeping the key facts and the same language:\n\n"+ex.Answer, maxRewriteTokens)
+	case "translate":
+		lang := "English"
+		if len(args) > 0 {
+			lang = strings.Join(args, " ")
+		}
+		answer, err = askUtility(ctx, "", fmt.Sprintf("Translate the following text into %s. Only output the translation:\n\n%s", lang, ex.Answer), maxRewriteTokens)
+	}
+	if err != nil {
+		return ex, err
+	}
+	ex.Answer = answer
+	return ex, nil
+}
+
+// replyText replies with text, split into LINE-sized messages.
+func replyText(bot *messaging_api.MessagingApiAPI, replyToken string, text string) {
+	replyMsg := []messaging_api.MessageInterface{}
+	for _, part := range splitString(text) {
+		replyMsg = append(replyMsg, &messaging_api.TextMessage{
+			Text: part,
+		})
+	}
+	_, err := bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
+		ReplyToken: replyToken,
+		Messages:   replyMsg,
+	})
+	if err != nil {
+		log.Printf("Error replying to message: %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"linebot-grok/models"
+	"linebot-grok/provider"
+	"linebot-grok/ratelimit"
+	"linebot-grok/utils"
+	"maps"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the effective server configuration: built-in defaults, then the
+// config file, then environment variables.
+type Config struct {
+	Server   Server           `yaml:"server"`
+	LINE     LINE             `yaml:"line"`
+	Grok     Provider         `yaml:"grok"`
+	Gemini   Provider         `yaml:"gemini"`
+	Bot      Bot              `yaml:"bot"`
+	Cache    Cache            `yaml:"cache"`
+	GroupLog GroupLog         `yaml:"groupLog"`
+	GeoIP    GeoIP            `yaml:"geoip"`
+	Geocode  Geocode          `yaml:"geocode"`
+	Feedback Feedback         `yaml:"feedback"`
+	Limits   Limits           `yaml:"limits"`
+	Pricing  provider.Pricing `yaml:"pricing"`
+	Models   *models.Catalog  `yaml:"models,omitempty"`
+}
+
+type Server struct {
+	Port        string        `yaml:"port"`
+	ReadTimeout time.Duration `yaml:"readTimeout"`
+	// WriteTimeout bounds a whole request, so it must outlast a provider
+	// call with all its retries or slow answers are dropped.
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+	// PublicBaseURL is where this server is reachable, e.g. https://bot.example.com.
+	// When empty it is derived from the LINE webhook endpoint.
+	PublicBaseURL string `yaml:"publicBaseURL"`
+	// PublicBaseURLRefresh is how often the webhook endpoint is looked up again.
+	PublicBaseURLRefresh time.Duration `yaml:"publicBaseURLRefresh"`
+	// TrustedProxies are the CIDRs (or single addresses) of reverse proxies
+	// whose X-Forwarded-For and Forwarded headers are believed.
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// AdminToken is the bearer token of the /admin endpoints, which are
+	// disabled when it is empty.
+	AdminToken string `yaml:"adminToken" secret:"true"`
+	// CORSOrigins are the web origins allowed to call the chat routes from a
+	// browser, e.g. https://app.example.com. "*" allows any origin.
+	CORSOrigins []string `yaml:"corsOrigins"`
+	// APIKeysFile is where issued API keys are saved. When empty they are
+	// kept in memory and lost on restart.
+	APIKeysFile string `yaml:"apiKeysFile"`
+}
+
+type LINE struct {
+	ChannelSecret  string `yaml:"channelSecret" secret:"true"`
+	ChannelToken   string `yaml:"channelToken" secret:"true"`
+	PostbackSecret string `yaml:"postbackSecret" secret:"true"`
+	// APIEndpoint replaces https://api.line.me, e.g. to point at a fake Messaging API.
+	APIEndpoint string `yaml:"apiEndpoint"`
+}
+
+type Provider struct {
+	APIKey string `yaml:"apiKey" secret:"true"`
+	// BaseURL overrides the provider's API root; empty means the public API.
+	BaseURL string `yaml:"baseURL"`
+	// Timeout bounds a single HTTP attempt, not the retries around it.
+	Timeout time.Duration          `yaml:"timeout"`
+	Retry   provider.RetryPolicy   `yaml:"retry"`
+	Breaker provider.BreakerPolicy `yaml:"breaker"`
+}
+
+// longestCall is how long a call can take when every attempt times out
+// after the longest wait between them.
+func (p Provider) longestCall() time.Duration {
+	attempts := time.Duration(max(p.Retry.MaxAttempts, 1))
+	return attempts*p.Timeout + (attempts-1)*p.Retry.MaxDelay
+}
+
+type Bot struct {
+	ChatPrefix       string `yaml:"chatPrefix"`
+	ImagePrefix      string `yaml:"imagePrefix"`
+	CommandPrefix    string `yaml:"commandPrefix"`
+	WelcomeMessage   string `yaml:"welcomeMessage"`
+	SystemPromptFile string `yaml:"systemPromptFile"`
+	// Admins are the LINE user IDs allowed to run admin commands such as "AI key".
+	Admins []string `yaml:"admins"`
+}
+
+type Cache struct {
+	ContextTTL time.Duration `yaml:"contextTTL"`
+	ImageTTL   time.Duration `yaml:"imageTTL"`
+}
+
+type GroupLog struct {
+	MaxMessages int           `yaml:"maxMessages"`
+	MaxAge      time.Duration `yaml:"maxAge"`
+}
+
+type GeoIP struct {
+	DBPath string `yaml:"dbPath"`
+	// Location reported for private and loopback addresses.
+	PrivateLocation string `yaml:"privateLocation"`
+	// ReloadInterval is how often the file is checked for changes.
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+type Geocode struct {
+	// CitiesFile is a GeoNames cities file used to name shared locations.
+	// The built-in list of major cities is used when empty.
+	CitiesFile string `yaml:"citiesFile"`
+}
+
+type Feedback struct {
+	// File is the JSONL file answer ratings are appended to. Ratings are
+	// not kept when it is empty.
+	File string `yaml:"file"`
+}
+
+// Limits are per-user rate limits and daily quotas. Zero values mean no limit.
+type Limits struct {
+	Chat   ratelimit.Policy `yaml:"chat"`
+	Search ratelimit.Policy `yaml:"search"`
+	Image  ratelimit.Policy `yaml:"image"`
+	// TimeZone is where daily quotas reset at midnight.
+	TimeZone string `yaml:"timeZone"`
+	// File is where the day's request counts are saved, so a restart doesn't
+	// reset daily quotas. They are kept in memory only when it is empty.
+	File string `yaml:"file"`
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:                 "8080",
+			ReadTimeout:          30 * time.Second,
+			WriteTimeout:         4 * time.Minute,
+			PublicBaseURLRefresh: time.Hour,
+			TrustedProxies:       slices.Clone(utils.DefaultTrustedProxies),
+			APIKeysFile:          "./api_keys.json",
+		},
+		Grok:   Provider{Timeout: 60 * time.Second, Retry: provider.DefaultRetry, Breaker: provider.DefaultBreaker},
+		Gemini: Provider{Timeout: 60 * time.Second, Retry: provider.DefaultRetry, Breaker: provider.DefaultBreaker},
+		Bot: Bot{
+			ChatPrefix:       "AI@",
+			ImagePrefix:      "AI#",
+			CommandPrefix:    "AI ",
+			SystemPromptFile: "./system_prompt.txt",
+		},
+		Cache: Cache{
+			ContextTTL: 5 * time.Minute,
+			ImageTTL:   180 * time.Minute,
+		},
+		GroupLog: GroupLog{
+			MaxMessages: 500,
+			MaxAge:      24 * time.Hour,
+		},
+		Limits: Limits{
+			Chat:     ratelimit.Policy{PerMinute: 10, Daily: 200},
+			Search:   ratelimit.Policy{PerMinute: 5, Daily: 100},
+			Image:    ratelimit.Policy{PerMinute: 2, Daily: 20},
+			TimeZone: "Asia/Taipei",
+			File:     "./quotas.json",
+		},
+		Pricing: maps.Clone(provider.DefaultPricing),
+		GeoIP: GeoIP{
+			DBPath:          "./GeoLite2-City.mmdb",
+			PrivateLocation: "Taiwan Taipei",
+			ReloadInterval:  time.Minute,
+		},
+		Feedback: Feedback{File: "./feedback.jsonl"},
+	}
+}
+
+// Load reads the YAML file at path on top of the defaults and applies
+// environment overrides. A missing file is fine when path is the default one.
+func Load(path string, required bool) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		// Relative paths in the file are relative to the file itself.
+		cfg.resolvePaths(filepath.Dir(path))
+	case errors.Is(err, fs.ErrNotExist) && !required:
+	default:
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) resolvePaths(dir string) {
+	for _, p := range []*string{&c.Bot.SystemPromptFile, &c.GeoIP.DBPath, &c.Geocode.CitiesFile, &c.Feedback.File, &c.Server.APIKeysFile, &c.Limits.File} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+}
+
+// applyEnv applies the environment variables the bot has always supported.
+func (c *Config) applyEnv() error {
+	strs := map[string]*string{
+		"PORT":               &c.Server.Port,
+		"PUBLIC_BASE_URL":    &c.Server.PublicBaseURL,
+		"ADMIN_TOKEN":        &c.Server.AdminToken,
+		"API_KEYS_FILE":      &c.Server.APIKeysFile,
+		"CHANNEL_SECRET":     &c.LINE.ChannelSecret,
+		"CHANNEL_TOKEN":      &c.LINE.ChannelToken,
+		"POSTBACK_SECRET":    &c.LINE.PostbackSecret,
+		"LINE_API_ENDPOINT":  &c.LINE.APIEndpoint,
+		"GROK_API_KEY":       &c.Grok.APIKey,
+		"GEMINI_API_KEY":     &c.Gemini.APIKey,
+		"GROK_BASE_URL":      &c.Grok.BaseURL,
+		"GEMINI_BASE_URL":    &c.Gemini.BaseURL,
+		"WELCOME_MESSAGE":    &c.Bot.WelcomeMessage,
+		"SYSTEM_PROMPT_FILE": &c.Bot.SystemPromptFile,
+		"GEOIP_DB_PATH":      &c.GeoIP.DBPath,
+		"CITIES_FILE":        &c.Geocode.CitiesFile,
+		"FEEDBACK_FILE":      &c.Feedback.File,
+		"QUOTAS_FILE":        &c.Limits.File,
+	}
+	for name, p := range strs {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*p = v
+		}
+	}
+
+	problems := []string{}
+	durations := map[string]*time.Duration{
+		"CONTEXT_TTL":       &c.Cache.ContextTTL,
+		"IMAGE_TTL":         &c.Cache.ImageTTL,
+		"GROUP_LOG_MAX_AGE": &c.GroupLog.MaxAge,
+	}
+	for name, p := range durations {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			*p = d
+		}
+	}
+	lists := map[string]*[]string{
+		"TRUSTED_PROXIES": &c.Server.TrustedProxies,
+		"CORS_ORIGINS":    &c.Server.CORSOrigins,
+		"ADMIN_USER_IDS":  &c.Bot.Admins,
+	}
+	for name, p := range lists {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*p = strings.Split(v, ",")
+		}
+	}
+	if v, ok := os.LookupEnv("GROUP_LOG_MAX_MESSAGES"); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("GROUP_LOG_MAX_MESSAGES: %v", err))
+		} else {
+			c.GroupLog.MaxMessages = n
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid environment:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (c *Config) Validate() error {
+	problems := []string{}
+	if c.LINE.ChannelSecret == "" {
+		problems = append(problems, "line.channelSecret (CHANNEL_SECRET) is required")
+	}
+	if c.LINE.ChannelToken == "" {
+		problems = append(problems, "line.channelToken (CHANNEL_TOKEN) is required")
+	}
+	if c.Grok.APIKey == "" && c.Gemini.APIKey == "" {
+		problems = append(problems, "at least one of grok.apiKey (GROK_API_KEY) or gemini.apiKey (GEMINI_API_KEY) is required")
+	}
+	if p, err := strconv.Atoi(c.Server.Port); err != nil || p <= 0 || p > 65535 {
+		problems = append(problems, fmt.Sprintf("server.port: %q is not a valid port", c.Server.Port))
+	}
+	if c.Server.PublicBaseURL != "" {
+		if u, err := url.Parse(c.Server.PublicBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("server.publicBaseURL: %q is not an http(s) URL", c.Server.PublicBaseURL))
+		}
+	}
+	for _, p := range c.Server.TrustedProxies {
+		p = strings.TrimSpace(p)
+		if _, err := netip.ParsePrefix(p); err != nil {
+			if _, err := netip.ParseAddr(p); err != nil {
+				problems = append(problems, fmt.Sprintf("server.trustedProxies: %q is not a CIDR or IP address", p))
+			}
+		}
+	}
+	for _, o := range c.Server.CORSOrigins {
+		o = strings.TrimSpace(o)
+		if o == "*" {
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			problems = append(problems, fmt.Sprintf("server.corsOrigins: %q is not an origin such as https://app.example.com", o))
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value string
+	}{
+		{"bot.chatPrefix", c.Bot.ChatPrefix},
+		{"bot.imagePrefix", c.Bot.ImagePrefix},
+		{"bot.commandPrefix", c.Bot.CommandPrefix},
+	} {
+		if strings.TrimSpace(p.value) == "" {
+			problems = append(problems, p.name+" must not be empty")
+		}
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.readTimeout", c.Server.ReadTimeout},
+		{"server.writeTimeout", c.Server.WriteTimeout},
+		{"server.publicBaseURLRefresh", c.Server.PublicBaseURLRefresh},
+		{"cache.contextTTL", c.Cache.ContextTTL},
+		{"cache.imageTTL", c.Cache.ImageTTL},
+		{"groupLog.maxAge", c.GroupLog.MaxAge},
+		{"geoip.reloadInterval", c.GeoIP.ReloadInterval},
+		{"grok.timeout", c.Grok.Timeout},
+		{"gemini.timeout", c.Gemini.Timeout},
+	} {
+		if d.value <= 0 {
+			problems = append(problems, d.name+" must be positive")
+		}
+	}
+	if c.GroupLog.MaxMessages <= 0 {
+		problems = append(problems, "groupLog.maxMessages must be positive")
+	}
+	for name, p := range map[string]Provider{"grok": c.Grok, "gemini": c.Gemini} {
+		if p.APIKey != "" && c.Server.WriteTimeout > 0 && c.Server.WriteTimeout <= p.longestCall() {
+			problems = append(problems, fmt.Sprintf("server.writeTimeout must be longer than %s, the longest a %s call can take with its timeout and retries", p.longestCall(), name))
+		}
+	}
+	for name, r := range map[string]provider.RetryPolicy{"grok": c.Grok.Retry, "gemini": c.Gemini.Retry} {
+		if r.MaxAttempts <= 0 {
+			problems = append(problems, name+".retry.maxAttempts must be positive")
+		}
+		if r.BaseDelay < 0 || r.MaxDelay < r.BaseDelay {
+			problems = append(problems, name+".retry: baseDelay must not be negative or above maxDelay")
+		}
+	}
+	for name, b := range map[string]provider.BreakerPolicy{"grok": c.Grok.Breaker, "gemini": c.Gemini.Breaker} {
+		if b.Threshold <= 0 || b.Cooldown <= 0 {
+			problems = append(problems, name+".breaker: threshold and cooldown must be positive")
+		}
+	}
+	for name, p := range map[string]ratelimit.Policy{"chat": c.Limits.Chat, "search": c.Limits.Search, "image": c.Limits.Image} {
+		if p.PerMinute < 0 || p.Burst < 0 || p.Daily < 0 {
+			problems = append(problems, "limits."+name+" must not be negative")
+		}
+	}
+	for id, p := range c.Pricing {
+		if p.InputPerMillion < 0 || p.OutputPerMillion < 0 || p.PerImage < 0 {
+			problems = append(problems, fmt.Sprintf("pricing.%s must not be negative", id))
+		}
+	}
+	if _, err := time.LoadLocation(c.Limits.TimeZone); err != nil {
+		problems = append(problems, fmt.Sprintf("limits.timeZone: %v", err))
+	}
+	if c.Models != nil {
+		if err := c.Models.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
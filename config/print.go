@@ -0,0 +1,40 @@
+package config
+
+import (
+	"io"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// Redacted returns a copy of the config with every field tagged
+// `secret:"true"` masked, for logging and --print-config.
+func (c *Config) Redacted() *Config {
+	out := *c
+	redact(reflect.ValueOf(&out).Elem())
+	return &out
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch {
+		case v.Type().Field(i).Tag.Get("secret") == "true":
+			if field.Kind() == reflect.String && field.String() != "" {
+				field.SetString(redacted)
+			}
+		case field.Kind() == reflect.Struct:
+			redact(field)
+		}
+	}
+}
+
+// Print writes the redacted config as YAML.
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(c.Redacted())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// allowedOrigin reports whether a browser page from origin may call the chat routes.
+func allowedOrigin(origin string) bool {
+	return slices.ContainsFunc(cfg.Server.CORSOrigins, func(o string) bool {
+		o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+		return o == "*" || strings.EqualFold(o, origin)
+	})
+}
+
+// cors sets the CORS headers for configured origins and answers preflight
+// requests, which carry no API key, before they reach next.
+func cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && allowedOrigin(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		}
+		if r.Method == http.MethodOptions {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"linebot-grok/provider"
+	"linebot-grok/ratelimit"
+	"strings"
+	"time"
+)
+
+// errorMessages are the replies shown to LINE users when a provider call fails.
+var errorMessages = map[string]map[provider.Kind]string{
+	"en": {
+		provider.KindAuth:        "The AI service is misconfigured. Please let the bot admin know.",
+		provider.KindRateLimited: "The AI service is busy right now. Please try again in a moment.",
+		provider.KindQuota:       "The AI service has run out of quota for now. Please try again later.",
+		provider.KindSafety:      "Sorry, I can't help with that request.",
+		provider.KindTimeout:     "The AI service took too long to answer. Please try again.",
+		provider.KindUnavailable: "The AI service is temporarily unavailable. Please try again later.",
+		provider.KindBadResponse: "The AI service returned an unexpected answer. Please try again.",
+		provider.KindUnknown:     "Sorry, I couldn't process your request.",
+	},
+	"zh": {
+		provider.KindAuth:        "AI 服務設定有誤，請通知機器人管理員。",
+		provider.KindRateLimited: "AI 服務目前忙碌中，請稍後再試。",
+		provider.KindQuota:       "AI 服務的額度暫時用完了，請稍後再試。",
+		provider.KindSafety:      "抱歉，這個請求我無法協助。",
+		provider.KindTimeout:     "AI 服務回應逾時，請再試一次。",
+		provider.KindUnavailable: "AI 服務暫時無法使用，請稍後再試。",
+		provider.KindBadResponse: "AI 服務回傳了無法處理的結果，請再試一次。",
+		provider.KindUnknown:     "抱歉，我無法處理您的請求。",
+	},
+}
+
+// limitMessages are shown when a user is over a rate limit or daily quota.
+var limitMessages = map[string]struct{ rate, daily string }{
+	"en": {
+		rate:  "You're sending requests too quickly. Please try again in %s.",
+		daily: "You've used all %d of today's %s requests. The quota resets at %s (in %s).",
+	},
+	"zh": {
+		rate:  "您的請求太頻繁了，請在 %s 後再試。",
+		daily: "您今天的 %[2]s 額度（%[1]d 次）已用完，將於 %[3]s 重置（還有 %[4]s）。",
+	},
+}
+
+// friendlyError returns the message for err in the conversation's language.
+func friendlyError(ref string, err error) string {
+	lang := "en"
+	if strings.HasPrefix(strings.ToLower(st.Settings(ref).Lang), "zh") {
+		lang = "zh"
+	}
+	var le *ratelimit.Error
+	if errors.As(err, &le) {
+		wait := le.RetryAfter.Round(time.Second)
+		if !le.Daily {
+			return fmt.Sprintf(limitMessages[lang].rate, shortDuration(max(wait, time.Second)))
+		}
+		return fmt.Sprintf(limitMessages[lang].daily, le.Limit, le.Kind, le.ResetAt.Format("15:04 MST"), shortDuration(wait.Round(time.Minute)))
+	}
+	return errorMessages[lang][provider.KindOf(err)]
+}
+
+// shortDuration formats d without trailing zero units, e.g. "16h49m" or "1m".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// defaultWelcomeMessage lists the commands with the configured prefixes.
+func defaultWelcomeMessage() string {
+	chat, image := cfg.Bot.ChatPrefix, cfg.Bot.ImagePrefix
+	return strings.Join([]string{
+		"Hi! Here is how to use me:",
+		chat + " <question> - ask anything (answers use web search)",
+		image + " <description> - generate an image",
+		image + "# <description> - generate an image with Grok",
+		command("log on|off") + " - record this group's messages for summaries",
+		command("sum [N|since 2h]") + " - summarize the recent discussion",
+		command("set persona <text>") + " - give me a personality",
+		command("set lang <language>") + " - choose my reply language",
+		command("set location <city>") + " - or share a location, for local answers",
+		command("usage") + " - your token usage, cost and remaining quota",
+		command("history") + " - list the recent questions with their IDs",
+		command("edit <id> <question>") + " - ask an earlier question differently and continue from there",
+		command("reset") + " - clear what I remember of this chat",
+		command("export") + " - download this chat's history",
+		command("forget me") + " - delete everything stored about you",
+		command("model [name]") + " - list or switch chat and image models",
+	}, "\n")
+}
+
+// welcomeMessage returns the configured welcome message if set. Literal "\n"
+// sequences are turned into line breaks so the text can live on a single .env line.
+func welcomeMessage() string {
+	msg := cfg.Bot.WelcomeMessage
+	if msg == "" {
+		return defaultWelcomeMessage()
+	}
+	return strings.ReplaceAll(msg, `\n`, "\n")
+}
+
+// welcomeQuickReply offers the most common commands as one-tap buttons.
+func welcomeQuickReply() *messaging_api.QuickReply {
+	items := []messaging_api.QuickReplyItem{}
+	for _, a := range []struct{ label, text string }{
+		{"Ask a question", cfg.Bot.ChatPrefix + " What can you do?"},
+		{"Draw a picture", cfg.Bot.ImagePrefix + " a cat sitting on the moon"},
+		{"Enable summaries", command("log on")},
+	} {
+		items = append(items, messaging_api.QuickReplyItem{
+			Type: "action",
+			Action: &messaging_api.MessageAction{
+				Label: a.label,
+				Text:  a.text,
+			},
+		})
+	}
+	return &messaging_api.QuickReply{Items: items}
+}
+
+// sendWelcome greets a user who added the bot or a group the bot joined.
+func sendWelcome(bot *messaging_api.MessagingApiAPI, replyToken string) {
+	_, err := bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
+		ReplyToken: replyToken,
+		Messages: []messaging_api.MessageInterface{
+			&messaging_api.TextMessage{
+				Text:       welcomeMessage(),
+				QuickReply: welcomeQuickReply(),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error sending welcome message: %v", err)
+	}
+}
+
+// purgeConversation drops history, images and settings kept for the source
+// after the bot is blocked or removed from a group.
+func purgeConversation(src webhook.SourceInterface) {
+	ref, _ := conversationRef(src)
+	if ref == "" {
+		return
+	}
+	c.Delete(ref)
+	st.Purge(ref)
+	log.Printf("Purged stored data for %s", ref)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"linebot-grok/feedback"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// feedbackLog keeps answer ratings for the evaluation dataset.
+var feedbackLog = feedback.New("")
+
+// sourcesHeader separates a search-grounded answer from its sources.
+const sourcesHeader = "\n[Sources]\n"
+
+// answerSources splits the source lines off a search-grounded answer.
+func answerSources(answer string) (string, []string) {
+	text, list, found := strings.Cut(answer, sourcesHeader)
+	if !found {
+		return answer, nil
+	}
+	sources := []string{}
+	for _, s := range strings.Split(list, "\n") {
+		if s = strings.TrimSpace(s); s != "" {
+			sources = append(sources, s)
+		}
+	}
+	return strings.TrimSpace(text), sources
+}
+
+// rateTurn records the caller's rating of the turn with id, or of the
+// latest answer when id is empty. It reports whether the turn was found.
+func rateTurn(ctx context.Context, ref string, id string, rating int) bool {
+	last, hasLast := st.LastExchange(ref)
+	turn, found := last, hasLast
+	if id != "" {
+		_, turn, found = turnsBefore(ref, id)
+	}
+	if !found {
+		return false
+	}
+	if hasLast && last.ID == turn.ID {
+		st.RateLastExchange(ref, rating)
+	}
+
+	response, sources := answerSources(turn.Answer)
+	err := feedbackLog.Add(feedback.Record{
+		Ref:      ref,
+		TurnID:   turn.ID,
+		UserID:   callerFrom(ctx).UserID,
+		Rating:   rating,
+		Prompt:   turn.Question,
+		Response: response,
+		Model:    turn.Model,
+		Sources:  sources,
+		Answered: turn.Time,
+		Rated:    time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error saving feedback: %v", err)
+	}
+	return true
+}
+
+// exportFeedback runs "export-feedback": it writes the rated answers in
+// the feedback file as a JSONL evaluation dataset.
+func exportFeedback(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export-feedback", flag.ContinueOnError)
+	file := flags.String("file", cfg.Feedback.File, "feedback file to read")
+	out := flags.String("o", "", "write the dataset to this file instead of stdout")
+	rating := flags.String("rating", "all", "ratings to export: up, down or all")
+	since := flags.String("since", "", "only export ratings given on or after this date (YYYY-MM-DD)")
+	model := flags.String("model", "", "only export answers of this model, e.g. grok")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var filter feedback.Filter
+	switch *rating {
+	case "up":
+		filter.Rating = 1
+	case "down":
+		filter.Rating = -1
+	case "all":
+	default:
+		return fmt.Errorf("-rating must be up, down or all, not %q", *rating)
+	}
+	if *since != "" {
+		t, err := time.ParseInLocation(time.DateOnly, *since, time.Local)
+		if err != nil {
+			return fmt.Errorf("-since: %v", err)
+		}
+		filter.Since = t
+	}
+	filter.Model = *model
+
+	if *file == "" {
+		return fmt.Errorf("no feedback file is configured (feedback.file or FEEDBACK_FILE)")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := feedback.Read(f)
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	var outFile *os.File
+	if *out != "" {
+		if outFile, err = os.Create(*out); err != nil {
+			return err
+		}
+		w = outFile
+	}
+	n, err := feedback.Export(w, records, filter)
+	if outFile != nil {
+		if cerr := outFile.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d rated answers\n", n)
+	return nil
+}
@@ -0,0 +1,197 @@
+package feedback
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+Ratings are appended to a JSONL file, one Record per line, so they survive
+restarts and can be exported while the bot is running. Rating the same
+answer again appends another line; Read keeps the latest one per user and
+answer.
+*/
+
+// Record is a user's rating of an answer with what was asked and answered.
+type Record struct {
+	Ref      string    `json:"ref"`
+	TurnID   string    `json:"turnId"`
+	UserID   string    `json:"userId,omitempty"`
+	Rating   int       `json:"rating"`
+	Prompt   string    `json:"prompt"`
+	Response string    `json:"response"`
+	Model    string    `json:"model"`
+	Sources  []string  `json:"sources,omitempty"`
+	Answered time.Time `json:"answered"`
+	Rated    time.Time `json:"rated"`
+}
+
+func (r Record) key() string {
+	return r.Ref + "|" + r.TurnID + "|" + r.UserID
+}
+
+// Log appends ratings to a file. A Log with an empty path drops them.
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+func New(path string) *Log {
+	return &Log{path: path}
+}
+
+// Add appends r to the log.
+func (l *Log) Add(r Record) error {
+	if l.path == "" {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Forget rewrites the log without the ratings of userID.
+func (l *Log) Forget(userID string) error {
+	if l.path == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	records, err := readAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	records = slices.DeleteFunc(records, func(r Record) bool { return r.UserID == userID })
+
+	tmp := l.path + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// Read returns the current ratings in r: the latest per user and answer, in
+// the order they were first given.
+func Read(r io.Reader) ([]Record, error) {
+	all, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]int{}
+	records := []Record{}
+	for _, rec := range all {
+		if i, ok := latest[rec.key()]; ok {
+			records[i] = rec
+			continue
+		}
+		latest[rec.key()] = len(records)
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func readAll(r io.Reader) ([]Record, error) {
+	records := []Record{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("feedback: line %d: %w", n, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// Example is a rated prompt and response in the evaluation dataset.
+type Example struct {
+	Prompt   string   `json:"prompt"`
+	Response string   `json:"response"`
+	Model    string   `json:"model"`
+	Sources  []string `json:"sources,omitempty"`
+	// Rating is 1 for thumbs up and -1 for thumbs down.
+	Rating int       `json:"rating"`
+	Time   time.Time `json:"time"`
+}
+
+// Filter selects the records to export. Zero values select everything.
+type Filter struct {
+	Rating int
+	Since  time.Time
+	Model  string
+}
+
+func (f Filter) match(r Record) bool {
+	return (f.Rating == 0 || r.Rating == f.Rating) &&
+		(f.Since.IsZero() || !r.Rated.Before(f.Since)) &&
+		(f.Model == "" || r.Model == f.Model)
+}
+
+// Export writes the records matching f to w as JSONL examples and returns
+// how many it wrote.
+func Export(w io.Writer, records []Record, f Filter) (int, error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	n := 0
+	for _, r := range records {
+		if !f.match(r) {
+			continue
+		}
+		err := enc.Encode(Example{
+			Prompt:   r.Prompt,
+			Response: r.Response,
+			Model:    r.Model,
+			Sources:  r.Sources,
+			Rating:   r.Rating,
+			Time:     r.Answered,
+		})
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
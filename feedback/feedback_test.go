@@ -0,0 +1,143 @@
+package feedback
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+func record(turn string, user string, rating int, model string, rated time.Time) Record {
+	return Record{
+		Ref:      "user:" + user,
+		TurnID:   turn,
+		UserID:   user,
+		Rating:   rating,
+		Prompt:   "question " + turn,
+		Response: "answer " + turn,
+		Model:    model,
+		Rated:    rated,
+	}
+}
+
+func turnIDs(records []Record) string {
+	ids := []string{}
+	for _, r := range records {
+		ids = append(ids, r.TurnID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestLogAndRead(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "feedback.jsonl"))
+	for _, r := range []Record{
+		record("t1", "U1", 1, "grok", day),
+		record("t2", "U1", -1, "gemini", day),
+		record("t1", "U2", -1, "grok", day),
+	} {
+		if err := l.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1].Model != "gemini" || records[2].UserID != "U2" {
+		t.Errorf("read %+v", records)
+	}
+}
+
+func TestReadLastRatingWins(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range []Record{
+		record("t1", "U1", 1, "grok", day),
+		record("t2", "U1", 1, "grok", day),
+		record("t1", "U1", -1, "grok", day.Add(time.Hour)),
+		// Another user's rating of the same answer is kept apart.
+		record("t1", "U2", 1, "grok", day),
+	} {
+		enc.Encode(r)
+	}
+	records, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := turnIDs(records); got != "t1,t2,t1" {
+		t.Fatalf("read turns %s, want t1,t2,t1", got)
+	}
+	if records[0].Rating != -1 || !records[0].Rated.Equal(day.Add(time.Hour)) {
+		t.Errorf("first record = %+v, want the later thumbs down in its place", records[0])
+	}
+	if records[2].UserID != "U2" || records[2].Rating != 1 {
+		t.Errorf("third record = %+v, want U2's thumbs up", records[2])
+	}
+}
+
+func TestReadSkipsBlankLines(t *testing.T) {
+	line, _ := json.Marshal(record("t1", "U1", 1, "grok", day))
+	records, err := Read(strings.NewReader("\n" + string(line) + "\n\n"))
+	if err != nil || len(records) != 1 {
+		t.Errorf("read %d records, %v", len(records), err)
+	}
+}
+
+func TestReadMalformedLine(t *testing.T) {
+	line, _ := json.Marshal(record("t1", "U1", 1, "grok", day))
+	_, err := Read(strings.NewReader(string(line) + "\n{\"ref\": \n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("err = %v, want one about line 2", err)
+	}
+}
+
+func TestExportFilter(t *testing.T) {
+	records := []Record{
+		record("t1", "U1", 1, "grok", day),
+		record("t2", "U1", -1, "grok", day.Add(48*time.Hour)),
+		record("t3", "U1", -1, "gemini", day.Add(24*time.Hour)),
+		record("t4", "U1", 1, "gemini", day.Add(72*time.Hour)),
+	}
+	for _, tc := range []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"everything", Filter{}, "t1,t2,t3,t4"},
+		{"thumbs up", Filter{Rating: 1}, "t1,t4"},
+		{"thumbs down", Filter{Rating: -1}, "t2,t3"},
+		{"since", Filter{Since: day.Add(24 * time.Hour)}, "t2,t3,t4"},
+		{"model", Filter{Model: "gemini"}, "t3,t4"},
+		{"all of them", Filter{Rating: -1, Since: day.Add(36 * time.Hour), Model: "grok"}, "t2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := Export(&buf, records, tc.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prompts := []string{}
+			dec := json.NewDecoder(&buf)
+			for dec.More() {
+				var ex Example
+				if err := dec.Decode(&ex); err != nil {
+					t.Fatal(err)
+				}
+				prompts = append(prompts, strings.TrimPrefix(ex.Prompt, "question "))
+			}
+			if got := strings.Join(prompts, ","); got != tc.want || n != len(prompts) {
+				t.Errorf("exported %s (n = %d), want %s", got, n, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"linebot-grok/feedback"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportFeedbackCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.jsonl")
+	l := feedback.New(path)
+	rated := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	for i, r := range []feedback.Record{
+		{TurnID: "t1", Rating: -1, Model: "grok", Prompt: "old"},
+		{TurnID: "t2", Rating: -1, Model: "grok", Prompt: "wanted"},
+		{TurnID: "t3", Rating: 1, Model: "grok", Prompt: "thumbs up"},
+		{TurnID: "t4", Rating: -1, Model: "gemini", Prompt: "other model"},
+	} {
+		r.Ref, r.UserID = "user:U1", "U1"
+		r.Rated = rated.AddDate(0, 0, 10*min(i, 1))
+		if err := l.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := exportFeedback([]string{"-file", path, "-rating", "down", "-model", "grok", "-since", "2026-10-05"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var ex feedback.Example
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &ex) != nil || ex.Prompt != "wanted" {
+		t.Errorf("exported %q", out.String())
+	}
+
+	for _, args := range [][]string{
+		{"-file", path, "-rating", "meh"},
+		{"-file", path, "-since", "yesterday"},
+		{"-file", ""},
+	} {
+		if err := exportFeedback(args, &out); err == nil {
+			t.Errorf("export-feedback %q succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package gemini
+
+import (
+	"errors"
+	"linebot-grok/provider"
+
+	"google.golang.org/genai"
+)
+
+const providerName = "gemini"
+
+// wrapError turns an error from the genai SDK into a provider error.
+func wrapError(err error) error {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		e := provider.FromStatus(providerName, apiErr.Code, nil, []byte(apiErr.Message))
+		if apiErr.Code == 0 {
+			e.Kind = provider.KindBadResponse
+		}
+		return e
+	}
+	return provider.FromTransport(providerName, err)
+}
+
+// checkBlocked reports a safety error if the prompt or every candidate was blocked.
+func checkBlocked(result *genai.GenerateContentResponse) error {
+	if result.PromptFeedback != nil && result.PromptFeedback.BlockReason != "" {
+		return provider.New(providerName, provider.KindSafety, "prompt blocked: %s", result.PromptFeedback.BlockReason)
+	}
+	if len(result.Candidates) == 0 {
+		return nil
+	}
+	for _, cand := range result.Candidates {
+		if !isSafetyFinish(string(cand.FinishReason)) {
+			return nil
+		}
+	}
+	return provider.New(providerName, provider.KindSafety, "response blocked: %s", result.Candidates[0].FinishReason)
+}
+
+func isSafetyFinish(reason string) bool {
+	switch genai.FinishReason(reason) {
+	case genai.FinishReasonSafety, genai.FinishReasonBlocklist, genai.FinishReasonProhibitedContent,
+		genai.FinishReasonSPII, genai.FinishReasonImageSafety, genai.FinishReasonRecitation:
+		return true
+	}
+	return false
+}
@@ -3,34 +3,100 @@ package gemini
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"linebot-grok/geo"
+	"linebot-grok/models"
+	"linebot-grok/prompt"
+	"linebot-grok/provider"
 	"linebot-grok/utils"
 	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/patrickmn/go-cache"
 	"google.golang.org/genai"
 )
 
-var imgCache = cache.New(180*time.Minute, 180*time.Minute)
+// Options configures the Gemini provider.
+type Options struct {
+	APIKey string
+	// BaseURL replaces https://generativelanguage.googleapis.com, e.g. to point at a mock server.
+	BaseURL string
+	Timeout time.Duration
+	Retry   provider.RetryPolicy
+	Breaker provider.BreakerPolicy
+}
+
+const defaultBaseURL = "https://generativelanguage.googleapis.com"
+
+var opts Options
+var client = provider.NewClient(providerName, 60*time.Second, provider.DefaultRetry, provider.DefaultBreaker)
+
+// Configure sets the provider options. It must be called before any request.
+func Configure(o Options) {
+	opts = o
+	client = provider.NewClient(providerName, o.Timeout, o.Retry, o.Breaker)
+}
+
+// baseURL returns the configured API root, without a trailing slash.
+func baseURL() string {
+	if opts.BaseURL != "" {
+		return strings.TrimRight(opts.BaseURL, "/")
+	}
+	return defaultBaseURL
+}
+
+// newGenaiClient creates an SDK client that uses the shared HTTP client.
+func newGenaiClient(ctx context.Context) (*genai.Client, error) {
+	c, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:     opts.APIKey,
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: client.HTTP,
+		HTTPOptions: genai.HTTPOptions{
+			BaseURL: baseURL() + "/",
+		},
+	})
+	if err != nil {
+		return nil, provider.New(providerName, provider.KindAuth, "failed to create client: %v", err)
+	}
+	return c, nil
+}
 
-func GenerateImageByGemini(host string, userMsg string) (string, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  os.Getenv("GEMINI_API_KEY"),
-		Backend: genai.BackendGeminiAPI,
+// generateContent calls the SDK with retries and checks for blocked responses.
+func generateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	c, err := newGenaiClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var result *genai.GenerateContentResponse
+	err = client.Run(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = c.Models.GenerateContent(ctx, model, contents, config)
+		if err != nil {
+			return wrapError(err)
+		}
+		return nil
 	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if err := checkBlocked(result); err != nil {
+		return nil, err
 	}
-	if client.ClientConfig().Backend == genai.BackendVertexAI {
-		fmt.Println("Calling VertexAI Backend...")
-	} else {
-		fmt.Println("Calling GeminiAPI Backend...")
+	return result, nil
+}
+
+// recordUsage reports the tokens of result, and images if any were made.
+func recordUsage(ctx context.Context, model string, result *genai.GenerateContentResponse, images int) {
+	u := provider.Usage{Provider: providerName, Model: model, Images: images}
+	if m := result.UsageMetadata; m != nil {
+		u.PromptTokens = int(m.PromptTokenCount)
+		u.CompletionTokens = int(m.CandidatesTokenCount + m.ThoughtsTokenCount)
 	}
+	provider.RecordUsage(ctx, u)
+}
+
+// GenerateImageByGemini returns the PNG data of the first image in the response.
+func GenerateImageByGemini(ctx context.Context, model string, userMsg string) ([]byte, error) {
 	maxOutputTokens := int32(256)
 	config := &genai.GenerateContentConfig{
 		HTTPOptions: &genai.HTTPOptions{
@@ -44,44 +110,60 @@ func GenerateImageByGemini(host string, userMsg string) (string, error) {
 
 	promptMsg := userMsg
 
-	result, err := client.Models.GenerateContent(ctx, "gemini-2.0-flash-exp-image-generation", genai.Text(promptMsg), config)
+	result, err := generateContent(ctx, model, genai.Text(promptMsg), config)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	// 提取圖片資料
 	for _, cand := range result.Candidates {
 		if cand.Content != nil {
 			for _, part := range cand.Content.Parts {
 				if part.InlineData != nil {
-					uuid := uuid.New().String()
-					imgKey := fmt.Sprintf("%s.png", uuid)
-					imgCache.SetDefault(imgKey, part.InlineData.Data)
-					return host + "/img/" + imgKey, nil
+					recordUsage(ctx, model, result, 1)
+					return part.InlineData.Data, nil
 				}
 			}
 		}
 	}
-	jdata, _ := json.MarshalIndent(result, "", "  ")
+	recordUsage(ctx, model, result, 0)
+	log.Printf("Gemini image response had no image data in %d candidates", len(result.Candidates))
+	return nil, provider.New(providerName, provider.KindBadResponse, "image data not found in response")
+}
 
-	fmt.Println("Image data not found in response", string(jdata))
-	return "", nil
+// DefaultMaxOutputTokens keeps chat answers short enough for a LINE reply.
+const DefaultMaxOutputTokens = 256
+
+// Turn is an earlier question and its answer, sent before a new message.
+type Turn struct {
+	Question string
+	Answer   string
 }
 
-func GenerateByGemini(userMsg string) (string, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey:  os.Getenv("GEMINI_API_KEY"),
-		Backend: genai.BackendGeminiAPI,
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to create Gemini client: %w", err)
-	}
-	if client.ClientConfig().Backend == genai.BackendVertexAI {
-		fmt.Println("Calling VertexAI Backend...")
-	} else {
-		fmt.Println("Calling GeminiAPI Backend...")
+// GenerateByGemini answers userMsg in at most DefaultMaxOutputTokens tokens.
+func GenerateByGemini(ctx context.Context, model string, systemInstruction string, userMsg string) (string, error) {
+	return GenerateText(ctx, model, systemInstruction, userMsg, DefaultMaxOutputTokens)
+}
+
+// ChatByGemini is GenerateByGemini after the turns of history.
+func ChatByGemini(ctx context.Context, model string, systemInstruction string, history []Turn, userMsg string) (string, error) {
+	contents := []*genai.Content{}
+	for _, t := range history {
+		contents = append(contents,
+			genai.NewContentFromText(t.Question, genai.RoleUser),
+			genai.NewContentFromText(t.Answer, genai.RoleModel),
+		)
 	}
-	maxOutputTokens := int32(256)
+	contents = append(contents, genai.NewContentFromText(userMsg, genai.RoleUser))
+	return generateText(ctx, model, systemInstruction, contents, DefaultMaxOutputTokens)
+}
+
+// GenerateText is GenerateByGemini with room for maxOutputTokens tokens,
+// e.g. to translate or summarize long texts.
+func GenerateText(ctx context.Context, model string, systemInstruction string, userMsg string, maxOutputTokens int32) (string, error) {
+	return generateText(ctx, model, systemInstruction, genai.Text(userMsg), maxOutputTokens)
+}
+
+func generateText(ctx context.Context, model string, systemInstruction string, contents []*genai.Content, maxOutputTokens int32) (string, error) {
 	config := &genai.GenerateContentConfig{
 		HTTPOptions: &genai.HTTPOptions{
 			APIVersion: "v1beta",
@@ -89,13 +171,15 @@ func GenerateByGemini(userMsg string) (string, error) {
 		MaxOutputTokens:    maxOutputTokens,
 		ResponseModalities: []string{"TEXT"},
 	}
+	if systemInstruction != "" {
+		config.SystemInstruction = genai.NewContentFromText(systemInstruction, genai.RoleUser)
+	}
 
-	promptMsg := userMsg
-
-	result, err := client.Models.GenerateContent(ctx, "gemini-2.0-flash", genai.Text(promptMsg), config)
+	result, err := generateContent(ctx, model, contents, config)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content: %w", err)
+		return "", err
 	}
+	recordUsage(ctx, model, result, 0)
 	return result.Text(), nil
 }
 
@@ -104,17 +188,6 @@ type CompletionsMessage struct {
 }
 
 func GeminiRoute(w http.ResponseWriter, r *http.Request) {
-	// 設定 CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*") // 或指定來源
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	// 處理預檢請求（OPTIONS）
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-
 	chatbotRequest := &CompletionsMessage{}
 
 	if err := json.NewDecoder(r.Body).Decode(&chatbotRequest); err != nil {
@@ -128,11 +201,11 @@ func GeminiRoute(w http.ResponseWriter, r *http.Request) {
 		log.Println("Could not determine client IP")
 		return
 	}
-	location := utils.GetLocationByIP(ip)
-	fmt.Println(ip, location, "ASDASD")
-	resp, err := GenerateByGeminiWithSearch(chatbotRequest.Content, location)
+	loc := geo.Lookup(ip, r.Header.Get("Accept-Language"))
+	model, _ := models.Get().First(models.ProviderGemini, models.KindChat)
+	resp, err := GenerateByGeminiWithSearch(r.Context(), model.ID, prompt.Default(), nil, chatbotRequest.Content, FromGeo(loc))
 	if err != nil {
-		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+		provider.WriteHTTPError(w, err)
 		log.Printf("Failed to generate response: %v", err)
 		return
 	}
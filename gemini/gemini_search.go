@@ -2,21 +2,39 @@ package gemini
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"linebot-grok/provider"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 )
 
 // GeminiAPIResponse represents the top-level structure of the Gemini API's content generation response.
 type GeminiAPIResponse struct {
-	Candidates []Candidate `json:"candidates"`
+	Candidates     []Candidate     `json:"candidates"`
+	PromptFeedback *PromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  UsageMetadata   `json:"usageMetadata"`
+}
+
+// UsageMetadata reports the tokens a request used.
+type UsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// PromptFeedback is set when the prompt itself was blocked.
+type PromptFeedback struct {
+	BlockReason string `json:"blockReason"`
 }
 
 // Candidate represents a single generated response candidate from the model.
 type Candidate struct {
 	Content           Content           `json:"content"`
+	FinishReason      string            `json:"finishReason"`
 	GroundingMetadata GroundingMetadata `json:"groundingMetadata"`
 }
 
@@ -66,25 +84,33 @@ type Segment struct {
 	Text       string `json:"text"`
 }
 
-func GenerateByGeminiWithSearch(userMsg string, location string) (string, error) {
-	// 從環境變數獲取 GEMINI_API_KEY
-	geminiAPIKey := os.Getenv("GEMINI_API_KEY")
+// GenerateByGeminiWithSearch answers with Google Search grounding. When the
+// user's location is known it is passed as retrieval coordinates and
+// described in the system instruction. The turns of history are sent
+// before userMsg.
+func GenerateByGeminiWithSearch(ctx context.Context, model string, systemInstruction string, history []Turn, userMsg string, location *LocationContext) (string, error) {
+	geminiAPIKey := opts.APIKey
 	if geminiAPIKey == "" {
-		return "", fmt.Errorf("GEMINI_API_KEY not set in environment variables")
+		return "", provider.New(providerName, provider.KindAuth, "API key is not configured")
 	}
 
 	// 定義 API 端點
-	url := "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent?key=" + geminiAPIKey
+	url := baseURL() + "/v1beta/models/" + model + ":generateContent?key=" + geminiAPIKey
 
 	// 定義請求的內容 (request body)
+	contents := []map[string]interface{}{}
+	for _, t := range history {
+		contents = append(contents,
+			map[string]interface{}{"role": "user", "parts": []map[string]string{{"text": t.Question}}},
+			map[string]interface{}{"role": "model", "parts": []map[string]string{{"text": t.Answer}}},
+		)
+	}
+	contents = append(contents, map[string]interface{}{
+		"role":  "user",
+		"parts": []map[string]string{{"text": userMsg}},
+	})
 	requestBody := map[string]interface{}{
-		"contents": []map[string]interface{}{
-			{
-				"parts": []map[string]string{
-					{"text": fmt.Sprintf("%s\nLocation: %s", userMsg, location)},
-				},
-			},
-		},
+		"contents": contents,
 		"tools": []map[string]interface{}{
 			{
 				"google_search": map[string]interface{}{}, // 啟用 Google Search 工具
@@ -92,47 +118,63 @@ func GenerateByGeminiWithSearch(userMsg string, location string) (string, error)
 		},
 	}
 
-	// 將請求內容編碼為 JSON
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", err
+	if location != nil {
+		if tc := location.toolConfig(); tc != nil {
+			requestBody["toolConfig"] = tc
+		}
+		if li := location.Instruction(time.Now()); li != "" {
+			systemInstruction = strings.TrimSpace(systemInstruction + "\n\n" + li)
+		}
 	}
 
-	// 創建一個新的 HTTP 請求
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", err
+	if systemInstruction != "" {
+		requestBody["systemInstruction"] = map[string]interface{}{
+			"parts": []map[string]string{
+				{"text": systemInstruction},
+			},
+		}
 	}
 
-	// 設定 Content-Type header
-	req.Header.Set("Content-Type", "application/json")
-
-	// 發送請求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// 將請求內容編碼為 JSON
+	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", err
+		return "", provider.FromTransport(providerName, err)
 	}
-	defer resp.Body.Close() // 確保在函數結束時關閉響應體
 
-	// 讀取響應內容
-	responseBody, err := io.ReadAll(resp.Body)
+	// 發送請求 (失敗時自動重試)
+	responseBody, err := client.Do(ctx, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		// 設定 Content-Type header
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
 		return "", err
 	}
 
-	// 打印響應狀態碼和內容
-	fmt.Printf("Response Status: %s\n", resp.Status)
-	fmt.Printf("Response Body: %s\n", responseBody)
-
 	var response GeminiAPIResponse
 	err = json.Unmarshal(responseBody, &response)
 	if err != nil {
-		return "", err
+		return "", provider.New(providerName, provider.KindBadResponse, "failed to parse response: %v", err)
+	}
+	provider.RecordUsage(ctx, provider.Usage{
+		Provider:         providerName,
+		Model:            model,
+		PromptTokens:     response.UsageMetadata.PromptTokenCount,
+		CompletionTokens: response.UsageMetadata.CandidatesTokenCount + response.UsageMetadata.ThoughtsTokenCount,
+	})
+	if response.PromptFeedback != nil && response.PromptFeedback.BlockReason != "" {
+		return "", provider.New(providerName, provider.KindSafety, "prompt blocked: %s", response.PromptFeedback.BlockReason)
 	}
 
 	if len(response.Candidates) > 0 {
 		candidate := response.Candidates[0]
+		if isSafetyFinish(candidate.FinishReason) {
+			return "", provider.New(providerName, provider.KindSafety, "response blocked: %s", candidate.FinishReason)
+		}
 		result := ""
 		modelResp := ""
 
@@ -152,5 +194,5 @@ func GenerateByGeminiWithSearch(userMsg string, location string) (string, error)
 		// 返回第一個候選者的文本內容
 		return result, nil
 	}
-	return "", fmt.Errorf("no candidates found in response")
+	return "", provider.New(providerName, provider.KindBadResponse, "no candidates found in response")
 }
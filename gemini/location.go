@@ -0,0 +1,87 @@
+package gemini
+
+import (
+	"fmt"
+	"linebot-grok/geo"
+	"strings"
+	"time"
+)
+
+// LocationContext tells the model where the user is, so "weather today" or
+// "restaurants nearby" are answered for the right place and day.
+type LocationContext struct {
+	City        string
+	Country     string
+	CountryCode string
+	TimeZone    string
+	Lat         float64
+	Lng         float64
+	// HasCoordinates is set when Lat and Lng are known.
+	HasCoordinates bool
+}
+
+// FromGeo converts a GeoIP lookup result. It returns nil when nothing is known.
+func FromGeo(l geo.Location) *LocationContext {
+	if l.Country == "" && l.City == "" && !l.HasCoordinates {
+		return nil
+	}
+	return &LocationContext{
+		City:           l.City,
+		Country:        l.Country,
+		CountryCode:    l.CountryCode,
+		TimeZone:       l.TimeZone,
+		Lat:            l.Lat,
+		Lng:            l.Lng,
+		HasCoordinates: l.HasCoordinates,
+	}
+}
+
+// place returns "City, Country (CC)" with whatever parts are known.
+func (l *LocationContext) place() string {
+	parts := []string{}
+	for _, p := range []string{l.City, l.Country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	s := strings.Join(parts, ", ")
+	if l.CountryCode != "" {
+		s += " (" + l.CountryCode + ")"
+	}
+	return strings.TrimSpace(s)
+}
+
+// Instruction describes the location and local time as a system instruction.
+func (l *LocationContext) Instruction(now time.Time) string {
+	lines := []string{}
+	if p := l.place(); p != "" {
+		lines = append(lines, "The user is in "+p+".")
+	}
+	if l.HasCoordinates {
+		lines = append(lines, fmt.Sprintf("Their approximate coordinates are %.4f, %.4f.", l.Lat, l.Lng))
+	}
+	if tz, err := time.LoadLocation(l.TimeZone); l.TimeZone != "" && err == nil {
+		lines = append(lines, fmt.Sprintf("Their local time is %s (%s).", now.In(tz).Format("Monday, 2006-01-02 15:04"), l.TimeZone))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	lines = append(lines, "Use this for questions about weather, nearby places, opening hours, events and dates such as \"today\", unless the user names another place.")
+	return strings.Join(lines, " ")
+}
+
+// toolConfig returns the retrieval config that grounds search results at the
+// user's coordinates, or nil when they aren't known.
+func (l *LocationContext) toolConfig() map[string]any {
+	if !l.HasCoordinates {
+		return nil
+	}
+	return map[string]any{
+		"retrievalConfig": map[string]any{
+			"latLng": map[string]float64{
+				"latitude":  l.Lat,
+				"longitude": l.Lng,
+			},
+		},
+	}
+}
@@ -0,0 +1,41 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"linebot-grok/provider"
+
+	"google.golang.org/genai"
+)
+
+// SuggestFollowUps asks the model for short follow-up questions the user may
+// want to ask next. The model is constrained to a JSON array of strings.
+func SuggestFollowUps(ctx context.Context, model string, question string, answer string, max int) ([]string, error) {
+	maxItems := int64(max)
+	config := &genai.GenerateContentConfig{
+		MaxOutputTokens:  256,
+		ResponseMIMEType: "application/json",
+		ResponseSchema: &genai.Schema{
+			Type:     genai.TypeArray,
+			Items:    &genai.Schema{Type: genai.TypeString},
+			MaxItems: &maxItems,
+		},
+	}
+
+	prompt := fmt.Sprintf("Suggest up to %d short follow-up questions (under 20 characters each) the user might ask next, in the same language as the question.\n\nQuestion: %s\n\nAnswer: %s", max, question, answer)
+	result, err := generateContent(ctx, model, genai.Text(prompt), config)
+	if err != nil {
+		return nil, err
+	}
+	recordUsage(ctx, model, result, 0)
+
+	var suggestions []string
+	if err := json.Unmarshal([]byte(result.Text()), &suggestions); err != nil {
+		return nil, provider.New(providerName, provider.KindBadResponse, "failed to parse suggestions: %v", err)
+	}
+	if len(suggestions) > max {
+		suggestions = suggestions[:max]
+	}
+	return suggestions, nil
+}
@@ -0,0 +1,197 @@
+// Package geo looks IP addresses up in a MaxMind GeoLite2/GeoIP2 City
+// database. The database is opened once, reloaded when the file changes on
+// disk, and lookups degrade to "Unknown" while it is missing.
+package geo
+
+import (
+	"context"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Location is what the database knows about an address.
+type Location struct {
+	City        string
+	Country     string
+	CountryCode string
+	TimeZone    string
+	Lat         float64
+	Lng         float64
+	// HasCoordinates is false when the database has no position for the address.
+	HasCoordinates bool
+	// Private is set for private and loopback addresses, which are reported
+	// as the configured private location.
+	Private bool
+}
+
+// String returns "Country City" as the bot has always sent it, or "Unknown".
+func (l Location) String() string {
+	s := strings.TrimSpace(l.Country + " " + l.City)
+	if s == "" {
+		return "Unknown"
+	}
+	return s
+}
+
+type Options struct {
+	DBPath string
+	// PrivateLocation is reported for private and loopback addresses.
+	PrivateLocation string
+}
+
+// Service holds the open database.
+type Service struct {
+	opts Options
+
+	mu     sync.RWMutex
+	reader *geoip2.Reader
+	// size and modTime identify the file the reader was opened from.
+	size    int64
+	modTime time.Time
+}
+
+var (
+	serviceMu sync.RWMutex
+	service   = &Service{}
+)
+
+// Configure opens the database at o.DBPath. A missing or broken file is
+// logged and lookups return "Unknown" until a reload succeeds.
+func Configure(o Options) {
+	s := &Service{opts: o}
+	if err := s.Reload(); err != nil {
+		log.Printf("GeoIP database unavailable, locations will be Unknown: %v", err)
+	}
+	serviceMu.Lock()
+	old := service
+	service = s
+	serviceMu.Unlock()
+	old.close()
+}
+
+// Default returns the configured service.
+func Default() *Service {
+	serviceMu.RLock()
+	defer serviceMu.RUnlock()
+	return service
+}
+
+// Lookup looks ip up in the configured service.
+func Lookup(ip string, lang string) Location {
+	return Default().Lookup(ip, lang)
+}
+
+// Reload opens the database file again if it changed since it was last
+// opened. In-flight lookups finish on the old reader before it is closed.
+func (s *Service) Reload() error {
+	info, err := os.Stat(s.opts.DBPath)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	unchanged := s.reader != nil && info.Size() == s.size && info.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	reader, err := geoip2.Open(s.opts.DBPath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	old := s.reader
+	s.reader, s.size, s.modTime = reader, info.Size(), info.ModTime()
+	s.mu.Unlock()
+	if old != nil {
+		old.Close()
+		log.Printf("Reloaded GeoIP database %s", s.opts.DBPath)
+	}
+	return nil
+}
+
+// Watch reloads the database whenever the file changes, checking every interval.
+func (s *Service) Watch(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	lastErr := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// Only log a failure once, not on every tick while the file is missing.
+			err := s.Reload()
+			if err != nil && err.Error() != lastErr {
+				log.Printf("Failed to reload GeoIP database: %v", err)
+			}
+			lastErr = ""
+			if err != nil {
+				lastErr = err.Error()
+			}
+		}
+	}
+}
+
+// Available reports whether a database is open.
+func (s *Service) Available() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reader != nil
+}
+
+func (s *Service) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+}
+
+// Lookup returns the location of ip with names in lang (a language code or
+// name as users set it, e.g. "zh-TW" or "Japanese"), falling back to English.
+func (s *Service) Lookup(ip string, lang string) Location {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}
+	}
+	addr = addr.Unmap()
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return Location{Country: s.opts.PrivateLocation, Private: true}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.reader == nil {
+		return Location{}
+	}
+	record, err := s.reader.City(addr.AsSlice())
+	if err != nil {
+		log.Println("Error getting location for IP:", ip, "-", err)
+		return Location{}
+	}
+	locale := Locale(lang)
+	return Location{
+		City:           localName(record.City.Names, locale),
+		Country:        localName(record.Country.Names, locale),
+		CountryCode:    record.Country.IsoCode,
+		TimeZone:       record.Location.TimeZone,
+		Lat:            record.Location.Latitude,
+		Lng:            record.Location.Longitude,
+		HasCoordinates: record.Location.Latitude != 0 || record.Location.Longitude != 0,
+	}
+}
+
+func localName(names map[string]string, locale string) string {
+	if n, ok := names[locale]; ok {
+		return n
+	}
+	return names["en"]
+}
@@ -0,0 +1,34 @@
+package geo
+
+import "strings"
+
+// locales maps what users put in "AI set lang" or Accept-Language to the
+// name locales of GeoLite2 databases.
+var locales = []struct {
+	locale   string
+	prefixes []string
+}{
+	{"zh-CN", []string{"zh", "chinese", "中文", "繁體", "简体", "國語", "国语", "mandarin"}},
+	{"ja", []string{"ja", "japanese", "日本語"}},
+	{"de", []string{"de", "german", "deutsch"}},
+	{"es", []string{"es", "spanish", "español"}},
+	{"fr", []string{"fr", "french", "français"}},
+	{"pt-BR", []string{"pt", "portuguese", "português"}},
+	{"ru", []string{"ru", "russian", "русский"}},
+}
+
+// Locale returns the database locale for lang, or "en".
+func Locale(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	// Accept-Language: use the first, preferred tag.
+	lang, _, _ = strings.Cut(lang, ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	for _, l := range locales {
+		for _, p := range l.prefixes {
+			if strings.HasPrefix(lang, p) {
+				return l.locale
+			}
+		}
+	}
+	return "en"
+}
@@ -0,0 +1,55 @@
+package geocode
+
+// countryNames maps ISO 3166-1 alpha-2 codes to English names. GeoNames
+// cities files only carry the code.
+var countryNames = map[string]string{
+	"AE": "United Arab Emirates",
+	"AR": "Argentina",
+	"AT": "Austria",
+	"AU": "Australia",
+	"BE": "Belgium",
+	"BR": "Brazil",
+	"CA": "Canada",
+	"CH": "Switzerland",
+	"CL": "Chile",
+	"CN": "China",
+	"CO": "Colombia",
+	"CZ": "Czechia",
+	"DE": "Germany",
+	"DK": "Denmark",
+	"EG": "Egypt",
+	"ES": "Spain",
+	"FI": "Finland",
+	"FR": "France",
+	"GB": "United Kingdom",
+	"GR": "Greece",
+	"HK": "Hong Kong",
+	"ID": "Indonesia",
+	"IE": "Ireland",
+	"IL": "Israel",
+	"IN": "India",
+	"IT": "Italy",
+	"JP": "Japan",
+	"KE": "Kenya",
+	"KR": "South Korea",
+	"MO": "Macao",
+	"MX": "Mexico",
+	"MY": "Malaysia",
+	"NG": "Nigeria",
+	"NL": "Netherlands",
+	"NO": "Norway",
+	"NZ": "New Zealand",
+	"PE": "Peru",
+	"PH": "Philippines",
+	"PL": "Poland",
+	"PT": "Portugal",
+	"RU": "Russia",
+	"SE": "Sweden",
+	"SG": "Singapore",
+	"TH": "Thailand",
+	"TR": "Turkey",
+	"TW": "Taiwan",
+	"US": "United States",
+	"VN": "Vietnam",
+	"ZA": "South Africa",
+}
@@ -0,0 +1,211 @@
+// Package geocode turns coordinates into city names and city names into
+// coordinates using a local dataset, so no location leaves the server.
+//
+// Datasets use the GeoNames "cities" format (tab separated, e.g.
+// cities15000.txt from https://download.geonames.org/export/dump/). A small
+// dataset of major cities is built in.
+package geocode
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed cities.txt
+var builtinCities string
+
+// Place is a city of the dataset.
+type Place struct {
+	Name        string
+	CountryCode string
+	Lat         float64
+	Lng         float64
+	TimeZone    string
+	Population  int
+	// AltNames are other spellings and translations, used by Find.
+	AltNames []string
+}
+
+// Country returns the English country name, or the code if it isn't known.
+func (p Place) Country() string {
+	if name, ok := countryNames[p.CountryCode]; ok {
+		return name
+	}
+	return p.CountryCode
+}
+
+// String returns "City, Country".
+func (p Place) String() string {
+	return p.Name + ", " + p.Country()
+}
+
+// Dataset is a list of places.
+type Dataset struct {
+	places []Place
+}
+
+// Parse reads a GeoNames cities file.
+func Parse(r io.Reader) (*Dataset, error) {
+	d := &Dataset{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cols := strings.Split(text, "\t")
+		if len(cols) < 19 {
+			return nil, fmt.Errorf("line %d: expected 19 columns, got %d", line, len(cols))
+		}
+		lat, err1 := strconv.ParseFloat(cols[4], 64)
+		lng, err2 := strconv.ParseFloat(cols[5], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("line %d: invalid coordinates", line)
+		}
+		pop, _ := strconv.Atoi(cols[14])
+		p := Place{
+			Name:        cols[1],
+			CountryCode: cols[8],
+			Lat:         lat,
+			Lng:         lng,
+			TimeZone:    cols[17],
+			Population:  pop,
+		}
+		if cols[2] != "" && cols[2] != cols[1] {
+			p.AltNames = append(p.AltNames, cols[2])
+		}
+		if cols[3] != "" {
+			p.AltNames = append(p.AltNames, strings.Split(cols[3], ",")...)
+		}
+		d.places = append(d.places, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(d.places) == 0 {
+		return nil, fmt.Errorf("no places found")
+	}
+	return d, nil
+}
+
+// LoadFile reads a GeoNames cities file from disk.
+func LoadFile(path string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+// Nearest returns the place closest to lat, lng and its distance in km.
+func (d *Dataset) Nearest(lat float64, lng float64) (Place, float64) {
+	best := Place{}
+	bestDist := math.Inf(1)
+	for _, p := range d.places {
+		if dist := Distance(lat, lng, p.Lat, p.Lng); dist < bestDist {
+			best, bestDist = p, dist
+		}
+	}
+	return best, bestDist
+}
+
+// Find looks a place up by name, e.g. "Taipei", "台北" or "Portland, US".
+// When several places match, the most populous wins.
+func (d *Dataset) Find(query string) (Place, bool) {
+	name, country, _ := strings.Cut(query, ",")
+	name = strings.TrimSpace(name)
+	country = strings.TrimSpace(country)
+	if name == "" {
+		return Place{}, false
+	}
+
+	var best Place
+	found := false
+	for _, p := range d.places {
+		if country != "" && !strings.EqualFold(country, p.CountryCode) && !strings.EqualFold(country, p.Country()) {
+			continue
+		}
+		if !p.matches(name) {
+			continue
+		}
+		if !found || p.Population > best.Population {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+func (p Place) matches(name string) bool {
+	if strings.EqualFold(p.Name, name) {
+		return true
+	}
+	for _, alt := range p.AltNames {
+		if strings.EqualFold(alt, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Distance returns the great-circle distance between two points in km.
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+var (
+	mu      sync.RWMutex
+	current *Dataset
+)
+
+// Load installs the dataset at path, or the built-in one when path is empty.
+func Load(path string) error {
+	var d *Dataset
+	var err error
+	if path == "" {
+		d, err = Parse(strings.NewReader(builtinCities))
+	} else {
+		d, err = LoadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	current = d
+	return nil
+}
+
+// Get returns the installed dataset, loading the built-in one if needed.
+func Get() *Dataset {
+	mu.RLock()
+	d := current
+	mu.RUnlock()
+	if d == nil {
+		if err := Load(""); err != nil {
+			panic(err)
+		}
+		return Get()
+	}
+	return d
+}
@@ -0,0 +1,100 @@
+package geocode
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func loadFixture(t *testing.T) *Dataset {
+	t.Helper()
+	d, err := LoadFile("testdata/cities.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	d := loadFixture(t)
+	if len(d.places) != 4 {
+		t.Fatalf("parsed %d places, want 4", len(d.places))
+	}
+	p := d.places[0]
+	if p.Name != "Taipei" || p.CountryCode != "TW" || p.Lat != 25.04776 || p.Lng != 121.53185 ||
+		p.TimeZone != "Asia/Taipei" || p.Population != 7871900 {
+		t.Errorf("first place = %+v", p)
+	}
+	if strings.Join(p.AltNames, ",") != "台北,臺北" {
+		t.Errorf("alternate names = %q", p.AltNames)
+	}
+	if p.String() != "Taipei, Taiwan" {
+		t.Errorf("String() = %q", p.String())
+	}
+}
+
+func TestParseMalformedRows(t *testing.T) {
+	row := func(cols ...string) string { return strings.Join(cols, "\t") }
+	full := strings.Split("1\tTaipei\tTaipei\t\t25.0\t121.5\tP\tPPLA\tTW\t\t\t\t\t\t100\t\t\tAsia/Taipei\t2025-01-01", "\t")
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"no time zone or date", row(full[:17]...), "line 1: expected 19 columns, got 17"},
+		{"no modification date", row(full[:18]...), "line 1: expected 19 columns, got 18"},
+		{"bad latitude", row(append(append(full[:4:4], "north"), full[5:]...)...), "line 1: invalid coordinates"},
+		{"bad row after comments", "# cities\n\n" + row(full...) + "\n" + row(full[:5]...), "line 4: expected 19 columns, got 5"},
+		{"only comments", "# nothing here\n", "no places found"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tc.input))
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestNearest(t *testing.T) {
+	d := loadFixture(t)
+	for _, tc := range []struct {
+		name     string
+		lat, lng float64
+		want     string
+		distance float64
+	}{
+		{"Taipei 101", 25.0330, 121.5654, "Taipei, Taiwan", 3.76},
+		{"on the city", 22.61626, 120.31333, "Kaohsiung, Taiwan", 0},
+		{"middle of the US", 45.5, -100, "Portland, United States", 1761.05},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, dist := d.Nearest(tc.lat, tc.lng)
+			if p.String() != tc.want || math.Abs(dist-tc.distance) > 0.01 {
+				t.Errorf("Nearest = %s at %.2f km, want %s at %.2f km", p, dist, tc.want, tc.distance)
+			}
+		})
+	}
+	if p, _ := d.Nearest(45.5, -100); p.TimeZone != "America/Los_Angeles" {
+		t.Errorf("nearest Portland is in %s, want the west coast one", p.TimeZone)
+	}
+}
+
+func TestFind(t *testing.T) {
+	d := loadFixture(t)
+	if p, ok := d.Find("臺北"); !ok || p.Name != "Taipei" {
+		t.Errorf("Find(臺北) = %+v, %v", p, ok)
+	}
+	if p, ok := d.Find("portland"); !ok || p.TimeZone != "America/Los_Angeles" {
+		t.Errorf("Find(portland) = %+v, want the most populous", p)
+	}
+	if _, ok := d.Find("Portland, TW"); ok {
+		t.Error("found Portland in Taiwan")
+	}
+}
+
+func TestBuiltinDatasetParses(t *testing.T) {
+	if _, err := Parse(strings.NewReader(builtinCities)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/line/line-bot-sdk-go/v8 v8.12.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	google.golang.org/genai v1.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/oschwald/maxminddb-golang v1.13.0 // indirect
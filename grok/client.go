@@ -0,0 +1,111 @@
+package grok
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"linebot-grok/provider"
+	"net/http"
+	"strings"
+)
+
+const providerName = "grok"
+
+const defaultBaseURL = "https://api.x.ai/v1"
+
+// baseURL returns the configured API root, without a trailing slash.
+func baseURL() string {
+	if opts.BaseURL != "" {
+		return strings.TrimRight(opts.BaseURL, "/")
+	}
+	return defaultBaseURL
+}
+
+type ImageRequest struct {
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n,omitempty"` // Number of images (1-10, default 1)
+	Model          string `json:"model"`
+	ResponseFormat string `json:"response_format,omitempty"` // Optional: response format (e.g., "url", "b64_json")
+}
+
+// ImageResponse defines the structure for the API response
+type ImageResponse struct {
+	Data []struct {
+		URL string `json:"url"` // URL to the generated image
+	} `json:"data"`
+}
+
+// Complete sends a chat completion request to xAI.
+func Complete(ctx context.Context, request *GrokCompletionsRequest) (*GrokCompletionsResponse, error) {
+	var grokResp GrokCompletionsResponse
+	if err := post(ctx, "/chat/completions", request, &grokResp); err != nil {
+		return nil, err
+	}
+	provider.RecordUsage(ctx, provider.Usage{
+		Provider:         providerName,
+		Model:            request.Model,
+		PromptTokens:     grokResp.Usage.PromptTokens,
+		CompletionTokens: grokResp.Usage.CompletionTokens,
+	})
+	if len(grokResp.Choices) == 0 {
+		return nil, provider.New(providerName, provider.KindBadResponse, "no choices returned in response")
+	}
+	if grokResp.Choices[0].FinishReason == "content_filter" {
+		return nil, provider.New(providerName, provider.KindSafety, "response blocked by content filter")
+	}
+	return &grokResp, nil
+}
+
+// GenerateImage draws one image for prompt and returns its URL.
+func GenerateImage(ctx context.Context, model string, prompt string) (string, error) {
+	reqBody := ImageRequest{
+		Prompt:         prompt,
+		N:              1, // Request 1 image; adjust as needed (max 10)
+		Model:          model,
+		ResponseFormat: "url",
+	}
+	var imgResp ImageResponse
+	if err := post(ctx, "/images/generations", reqBody, &imgResp); err != nil {
+		return "", err
+	}
+	provider.RecordUsage(ctx, provider.Usage{Provider: providerName, Model: model, Images: len(imgResp.Data)})
+	if len(imgResp.Data) == 0 || imgResp.Data[0].URL == "" {
+		return "", provider.New(providerName, provider.KindBadResponse, "no image returned in response")
+	}
+	return imgResp.Data[0].URL, nil
+}
+
+// post sends a JSON request to the xAI API and decodes the JSON response into out.
+func post(ctx context.Context, path string, in any, out any) error {
+	apiKey := opts.APIKey
+	if apiKey == "" {
+		return provider.New(providerName, provider.KindAuth, "API key is not configured")
+	}
+
+	// Marshal the request to JSON
+	jsonData, err := json.Marshal(in)
+	if err != nil {
+		return provider.FromTransport(providerName, err)
+	}
+
+	body, err := client.Do(ctx, func(ctx context.Context) (*http.Request, error) {
+		// Create HTTP request
+		req, err := http.NewRequestWithContext(ctx, "POST", baseURL()+path, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, err
+		}
+
+		// Set headers
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return provider.New(providerName, provider.KindBadResponse, "error unmarshaling response: %v", err)
+	}
+	return nil
+}
@@ -1,13 +1,12 @@
 package grok
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
-	"io"
+	"linebot-grok/models"
+	"linebot-grok/provider"
 	"log"
 	"net/http"
-	"os"
+	"time"
 )
 
 /*
@@ -34,6 +33,16 @@ type GrokCompletionsMessage struct {
 type GrokCompletionsRequest struct {
 	Messages []*GrokCompletionsMessage `json:"messages"`
 	Model    string                    `json:"model"`
+	// MaxTokens bounds the answer; zero leaves it to the model.
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// ResponseFormat constrains the answer, e.g. to JSON matching a schema.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat asks for structured output: {"type": "json_schema", "json_schema": {...}}.
+type ResponseFormat struct {
+	Type       string         `json:"type"`
+	JSONSchema map[string]any `json:"json_schema,omitempty"`
 }
 
 type GrokCompletionsResponse struct {
@@ -58,22 +67,31 @@ type GrokCompletionsResponse struct {
 
 type ChatBotRequest []*GrokCompletionsMessage
 
-func GrokRoute(w http.ResponseWriter, r *http.Request) {
-	// 設定 CORS headers
-	w.Header().Set("Access-Control-Allow-Origin", "*") // 或指定來源
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// Options configures the Grok provider.
+type Options struct {
+	APIKey string
+	// BaseURL replaces https://api.x.ai/v1, e.g. to point at a mock server.
+	BaseURL string
+	Timeout time.Duration
+	Retry   provider.RetryPolicy
+	Breaker provider.BreakerPolicy
+}
 
-	// 處理預檢請求（OPTIONS）
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
+var opts Options
+var client = provider.NewClient(providerName, 60*time.Second, provider.DefaultRetry, provider.DefaultBreaker)
 
-	apiKey := os.Getenv("GROK_API_KEY")
-	if apiKey == "" {
-		log.Fatal("GROK_API_KEY not set in .env")
-	}
+// Configure sets the provider options. It must be called before any request.
+func Configure(o Options) {
+	opts = o
+	client = provider.NewClient(providerName, o.Timeout, o.Retry, o.Breaker)
+}
+
+// APIKey returns the configured xAI API key.
+func APIKey() string {
+	return opts.APIKey
+}
+
+func GrokRoute(w http.ResponseWriter, r *http.Request) {
 	chatbotRequest := &ChatBotRequest{}
 
 	if err := json.NewDecoder(r.Body).Decode(&chatbotRequest); err != nil {
@@ -81,54 +99,14 @@ func GrokRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	grokCompletionsRequest := &GrokCompletionsRequest{
+	model, _ := models.Get().First(models.ProviderGrok, models.KindChat)
+	grokCompletionsResponse, err := Complete(r.Context(), &GrokCompletionsRequest{
 		Messages: *chatbotRequest,
-		Model:    "grok-3-beta",
-	}
-
-	// Marshal the request to JSON
-	jsonData, err := json.Marshal(grokCompletionsRequest)
+		Model:    model.ID,
+	})
 	if err != nil {
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
-		return
-	}
-
-	// Create HTTP request
-	url := "https://api.x.ai/v1/chat/completions"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
-		return
-	}
-
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		http.Error(w, "Failed to send request", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Read and parse response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
-		return
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, fmt.Sprintf("Error: %s", body), resp.StatusCode)
-		return
-	}
-
-	var grokCompletionsResponse GrokCompletionsResponse
-	if err := json.Unmarshal(body, &grokCompletionsResponse); err != nil {
-		http.Error(w, "Failed to parse response", http.StatusInternalServerError)
+		provider.WriteHTTPError(w, err)
+		log.Printf("Failed to generate response: %v", err)
 		return
 	}
 	// Send the response back to the client
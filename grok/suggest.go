@@ -0,0 +1,45 @@
+package grok
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"linebot-grok/provider"
+)
+
+// SuggestFollowUps asks the model for short follow-up questions the user may
+// want to ask next. The model is constrained to a JSON object holding them.
+func SuggestFollowUps(ctx context.Context, model string, question string, answer string, max int) ([]string, error) {
+	prompt := fmt.Sprintf("Suggest up to %d short follow-up questions (under 20 characters each) the user might ask next, in the same language as the question.\n\nQuestion: %s\n\nAnswer: %s", max, question, answer)
+	resp, err := Complete(ctx, &GrokCompletionsRequest{
+		Model:    model,
+		Messages: []*GrokCompletionsMessage{{Role: "user", Content: prompt}},
+		ResponseFormat: &ResponseFormat{
+			Type: "json_schema",
+			JSONSchema: map[string]any{
+				"name": "follow_ups",
+				"schema": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"questions": map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "maxItems": max},
+					},
+					"required": []string{"questions"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out struct {
+		Questions []string `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(resp.Choices[0].Message.Content), &out); err != nil {
+		return nil, provider.New(providerName, provider.KindBadResponse, "failed to parse suggestions: %v", err)
+	}
+	if len(out.Questions) > max {
+		out.Questions = out.Questions[:max]
+	}
+	return out.Questions, nil
+}
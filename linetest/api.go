@@ -0,0 +1,247 @@
+package linetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// Token is the channel access token the fake API expects.
+const Token = "test-channel-token"
+
+// Message is a sent message, as the JSON LINE received.
+type Message map[string]any
+
+func (m Message) Type() string {
+	t, _ := m["type"].(string)
+	return t
+}
+
+// Text returns the text of a text message.
+func (m Message) Text() string {
+	t, _ := m["text"].(string)
+	return t
+}
+
+// QuickReplyLabels returns the labels of the message's quick reply buttons.
+func (m Message) QuickReplyLabels() []string {
+	labels := []string{}
+	qr, _ := m["quickReply"].(map[string]any)
+	items, _ := qr["items"].([]any)
+	for _, item := range items {
+		action, _ := item.(map[string]any)["action"].(map[string]any)
+		label, _ := action["label"].(string)
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// Reply is a captured ReplyMessage call.
+type Reply struct {
+	ReplyToken string    `json:"replyToken"`
+	Messages   []Message `json:"messages"`
+}
+
+// Texts returns the text of every message of the reply.
+func (r Reply) Texts() []string {
+	texts := []string{}
+	for _, m := range r.Messages {
+		texts = append(texts, m.Text())
+	}
+	return texts
+}
+
+// Push is a captured PushMessage call.
+type Push struct {
+	To       string    `json:"to"`
+	Messages []Message `json:"messages"`
+}
+
+// API is a fake Messaging API. Like LINE, it rejects a second reply with the
+// same token and replies of more than five messages.
+type API struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	replies []Reply
+	pushes  []Push
+	used    map[string]bool
+	names   map[string]string
+	content map[string][]byte
+	// WebhookEndpoint is returned by GetWebhookEndpoint.
+	WebhookEndpoint string
+}
+
+// NewAPI starts a fake Messaging API. Close it when done.
+func NewAPI() *API {
+	a := &API{
+		used:            map[string]bool{},
+		names:           map[string]string{},
+		content:         map[string][]byte{},
+		WebhookEndpoint: "https://bot.example.com/callback",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v2/bot/message/reply", a.reply)
+	mux.HandleFunc("POST /v2/bot/message/push", a.push)
+	mux.HandleFunc("GET /v2/bot/channel/webhook/endpoint", func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		writeJSON(w, map[string]any{"endpoint": a.WebhookEndpoint, "active": true})
+	})
+	mux.HandleFunc("GET /v2/bot/profile/{user}", a.profile)
+	mux.HandleFunc("GET /v2/bot/group/{group}/member/{user}", a.profile)
+	mux.HandleFunc("GET /v2/bot/room/{room}/member/{user}", a.profile)
+	mux.HandleFunc("GET /v2/bot/message/{id}/content", a.messageContent)
+	a.Server = httptest.NewServer(a.authorized(mux))
+	return a
+}
+
+// Client returns a Messaging API client talking to the fake.
+func (a *API) Client() *messaging_api.MessagingApiAPI {
+	bot, err := messaging_api.NewMessagingApiAPI(Token, messaging_api.WithEndpoint(a.URL))
+	if err != nil {
+		panic(err)
+	}
+	return bot
+}
+
+// BlobClient returns a client for message content talking to the fake.
+func (a *API) BlobClient() *messaging_api.MessagingApiBlobAPI {
+	blob, err := messaging_api.NewMessagingApiBlobAPI(Token, messaging_api.WithBlobEndpoint(a.URL))
+	if err != nil {
+		panic(err)
+	}
+	return blob
+}
+
+// SetProfile sets the display name returned for userID.
+func (a *API) SetProfile(userID string, displayName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.names[userID] = displayName
+}
+
+// SetContent sets the content of a message, e.g. an image sent by the user.
+func (a *API) SetContent(messageID string, data []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.content[messageID] = data
+}
+
+func (a *API) Replies() []Reply {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Reply{}, a.replies...)
+}
+
+// ReplyTo returns the reply sent with token.
+func (a *API) ReplyTo(token string) (Reply, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, r := range a.replies {
+		if r.ReplyToken == token {
+			return r, true
+		}
+	}
+	return Reply{}, false
+}
+
+func (a *API) Pushes() []Push {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Push{}, a.pushes...)
+}
+
+// Reset forgets captured calls and used reply tokens.
+func (a *API) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.replies = nil
+	a.pushes = nil
+	a.used = map[string]bool{}
+}
+
+func (a *API) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+Token {
+			writeError(w, http.StatusUnauthorized, "Authentication failed due to the following reason: invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *API) reply(w http.ResponseWriter, r *http.Request) {
+	var req Reply
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "The request body has 1 error(s)")
+		return
+	}
+	if len(req.Messages) == 0 || len(req.Messages) > 5 {
+		writeError(w, http.StatusBadRequest, "Size must be between 1 and 5")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if req.ReplyToken == "" || a.used[req.ReplyToken] {
+		writeError(w, http.StatusBadRequest, "Invalid reply token")
+		return
+	}
+	a.used[req.ReplyToken] = true
+	a.replies = append(a.replies, req)
+	writeJSON(w, map[string]any{"sentMessages": []any{}})
+}
+
+func (a *API) push(w http.ResponseWriter, r *http.Request) {
+	var req Push
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "The request body has 1 error(s)")
+		return
+	}
+	if len(req.Messages) == 0 || len(req.Messages) > 5 {
+		writeError(w, http.StatusBadRequest, "Size must be between 1 and 5")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pushes = append(a.pushes, req)
+	writeJSON(w, map[string]any{"sentMessages": []any{}})
+}
+
+func (a *API) profile(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	a.mu.Lock()
+	name, ok := a.names[user]
+	a.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	writeJSON(w, map[string]string{"userId": user, "displayName": name})
+}
+
+func (a *API) messageContent(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	data, ok := a.content[r.PathValue("id")]
+	a.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Write(data)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"linebot-grok/gemini"
+	"linebot-grok/geocode"
+	"linebot-grok/store"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// A shared location further than this from any known city is kept as bare
+// coordinates rather than named after a city it isn't in.
+const maxCityDistanceKm = 75
+
+// userRef is the ref of a user's one-to-one chat, where per-user state such
+// as their location is kept even when they talk in groups.
+func userRef(userID string) string {
+	return "user:" + userID
+}
+
+// placeLocation converts a dataset place to a stored location.
+func placeLocation(p geocode.Place) store.Location {
+	return store.Location{
+		Lat:         p.Lat,
+		Lng:         p.Lng,
+		City:        p.Name,
+		Country:     p.Country(),
+		CountryCode: p.CountryCode,
+		TimeZone:    p.TimeZone,
+		Time:        time.Now(),
+	}
+}
+
+// locationAt names the city at lat, lng using the local dataset.
+func locationAt(lat float64, lng float64) store.Location {
+	p, dist := geocode.Get().Nearest(lat, lng)
+	if dist > maxCityDistanceKm {
+		return store.Location{Lat: lat, Lng: lng, TimeZone: p.TimeZone, Time: time.Now()}
+	}
+	loc := placeLocation(p)
+	loc.Lat, loc.Lng = lat, lng
+	return loc
+}
+
+// locationMessage remembers a location shared by the sender.
+func locationMessage(bot *messaging_api.MessagingApiAPI, e webhook.MessageEvent, msg webhook.LocationMessageContent) {
+	_, userID := conversationRef(e.Source)
+	if userID == "" {
+		return
+	}
+	loc := locationAt(msg.Latitude, msg.Longitude)
+	loc.Address = msg.Address
+	st.SetLocation(userRef(userID), loc)
+	replyText(bot, e.ReplyToken, fmt.Sprintf("Got it, I'll use %s for local questions. Send %q to forget it.", loc, command("set location off")))
+}
+
+// setLocation handles "AI set location <city>|off".
+func setLocation(userID string, value string) string {
+	if userID == "" {
+		return "I can't tell who you are in this chat."
+	}
+	switch strings.ToLower(value) {
+	case "":
+		if loc, ok := st.Location(userRef(userID)); ok {
+			return fmt.Sprintf("Your location is %s. Share a location or send %q to change it.", loc, command("set location <city>"))
+		}
+		return fmt.Sprintf("I don't know your location. Share a location or send %q.", command("set location <city>"))
+	case "off", "clear", "none":
+		st.ClearLocation(userRef(userID))
+		return "Location forgotten."
+	}
+	p, ok := geocode.Get().Find(value)
+	if !ok {
+		return fmt.Sprintf("I don't know a city called %q. Try sharing your location instead.", value)
+	}
+	st.SetLocation(userRef(userID), placeLocation(p))
+	return fmt.Sprintf("Got it, I'll use %s for local questions.", p)
+}
+
+// userLocation returns the location given to the model for questions from
+// userID, or nil if they haven't shared one.
+func userLocation(userID string) *store.Location {
+	if userID == "" {
+		return nil
+	}
+	if loc, ok := st.Location(userRef(userID)); ok {
+		return &loc
+	}
+	return nil
+}
+
+// locationContext converts a stored location for search grounding.
+func locationContext(loc *store.Location) *gemini.LocationContext {
+	if loc == nil {
+		return nil
+	}
+	return &gemini.LocationContext{
+		City:           loc.City,
+		Country:        loc.Country,
+		CountryCode:    loc.CountryCode,
+		TimeZone:       loc.TimeZone,
+		Lat:            loc.Lat,
+		Lng:            loc.Lng,
+		HasCoordinates: true,
+	}
+}
@@ -1,291 +1,100 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"io/fs"
+	"linebot-grok/config"
 	"linebot-grok/gemini"
+	"linebot-grok/geo"
 	"linebot-grok/grok"
-	"linebot-grok/utils"
+	"linebot-grok/models"
+	"linebot-grok/provider"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"linebot-grok/tokens"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata" // local times for users' time zones, even without system zoneinfo
 
 	"github.com/joho/godotenv"
-	"github.com/line/line-bot-sdk-go/v8/linebot"
 	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
-	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
 	"github.com/patrickmn/go-cache"
 )
 
+var cfg = config.Default()
 var c = cache.New(5*time.Minute, 10*time.Minute)
-var imgCache = cache.New(180*time.Minute, 180*time.Minute)
-var host = ""
 
-// Request struct for the Grok API
-type GrokRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-}
-
-// Message struct for chat messages
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-// Response struct to parse Grok API response
-type GrokResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-			Role    string `json:"role"`
-		} `json:"message"`
-	} `json:"choices"`
-}
-
-type ImageRequest struct {
-	Prompt         string `json:"prompt"`
-	N              int    `json:"n,omitempty"` // Number of images (1-10, default 1)
-	Model          string `json:"model"`
-	ResponseFormat string `json:"response_format,omitempty"` // Optional: response format (e.g., "url", "b64_json")
-}
-
-// ImageResponse defines the structure for the API response
-type ImageResponse struct {
-	Data []struct {
-		URL string `json:"url"` // URL to the generated image
-	} `json:"data"`
-}
-
-// Hypothetical Grok API function (replace with actual implementation if available)
-func callGrokAPI(chatID string, message string) (string, error) {
-	apiKey := os.Getenv("GROK_API_KEY")
-	if apiKey == "" {
-		log.Fatal("GROK_API_KEY not set in .env")
+// fitTurns returns the turns of history that fit in the model's token
+// budget after the system prompt and the new message, which are always sent.
+func fitTurns(history []store.Exchange, model models.Model, systemPrompt string, message string) []store.Exchange {
+	budget := model.ContextBudget() - tokens.Message(message)
+	if systemPrompt != "" {
+		budget -= tokens.Message(systemPrompt)
 	}
+	kept, _ := splitTurns(history, budget)
+	return kept
+}
 
-	// Define the request payload
-	request := GrokRequest{
-		Model:    "grok-3-beta", // Use "grok-beta" or another available model
-		Messages: []Message{},
+// callGrokAPI chats with Grok after the turns of history, trimmed by
+// fitTurns.
+func callGrokAPI(ctx context.Context, history []store.Exchange, model models.Model, systemPrompt string, message string) (string, error) {
+	payload := []*grok.GrokCompletionsMessage{}
+	if systemPrompt != "" {
+		payload = append(payload, &grok.GrokCompletionsMessage{Role: "system", Content: systemPrompt})
 	}
-	// check have context in cache
-	if context, found := c.Get(chatID); found {
-		ctxMsgs := context.([]Message)
-
-		// If context found, append it to the request
-		request.Messages = append(request.Messages, ctxMsgs...)
+	for _, t := range fitTurns(history, model, systemPrompt, message) {
+		payload = append(payload,
+			&grok.GrokCompletionsMessage{Role: "user", Content: t.Question},
+			&grok.GrokCompletionsMessage{Role: "assistant", Content: t.Answer},
+		)
 	}
-
-	thisUserMsg := Message{
+	payload = append(payload, &grok.GrokCompletionsMessage{
 		Role:    "user",
 		Content: message,
-	}
-	// Append the user message to the request
-	request.Messages = append(request.Messages, thisUserMsg)
-
-	// Marshal the request to JSON
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return "", err
-	}
-
-	// Create HTTP request
-	url := "https://api.x.ai/v1/chat/completions"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+	})
 
-	// Read and parse response
-	body, err := io.ReadAll(resp.Body)
+	grokResp, err := grok.Complete(ctx, &grok.GrokCompletionsRequest{
+		Model:    model.ID,
+		Messages: payload,
+	})
 	if err != nil {
 		return "", err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status: %d", resp.StatusCode)
-	}
-
-	var grokResp GrokResponse
-	err = json.Unmarshal(body, &grokResp)
-	if err != nil {
-		return "", fmt.Errorf("Error unmarshaling response: %v", err)
-	}
-
-	// Print the response
-	result := "No choices returned in response"
-	if len(grokResp.Choices) > 0 {
-		result = grokResp.Choices[0].Message.Content
-		request.Messages = append(request.Messages, Message{
-			Role:    grokResp.Choices[0].Message.Role,
-			Content: grokResp.Choices[0].Message.Content,
-		})
-	}
-
-	// Store the context in cache
-	if len(request.Messages) > 10 {
-		request.Messages = request.Messages[len(request.Messages)-10:]
-	}
-	c.Set(chatID, request.Messages, cache.DefaultExpiration)
-
-	return result, nil
+	return grokResp.Choices[0].Message.Content, nil
 }
 
-// Hypothetical Grok API function (replace with actual implementation if available)
-func getImgPromptByGrok(message string) (string, error) {
-	apiKey := os.Getenv("GROK_API_KEY")
-	if apiKey == "" {
-		log.Fatal("GROK_API_KEY not set in .env")
-	}
-
-	// Define the request payload
-	request := GrokRequest{
-		Model:    "grok-3-beta", // Use "grok-beta" or another available model
-		Messages: []Message{},
-	}
-
-	thisUserMsg := Message{
-		Role:    "user",
-		Content: fmt.Sprintf("you only output prompt for image generate. this is my msg:%s. only output prompt", message),
-	}
-	// Append the user message to the request
-	request.Messages = append(request.Messages, thisUserMsg)
-
-	// Marshal the request to JSON
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return "", err
-	}
-
-	// Create HTTP request
-	url := "https://api.x.ai/v1/chat/completions"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// Read and parse response
-	body, err := io.ReadAll(resp.Body)
+// getImgPromptByGrok asks Grok to turn the user's message into an image prompt.
+func getImgPromptByGrok(ctx context.Context, model string, message string) (string, error) {
+	grokResp, err := grok.Complete(ctx, &grok.GrokCompletionsRequest{
+		Model: model,
+		Messages: []*grok.GrokCompletionsMessage{{
+			Role:    "user",
+			Content: fmt.Sprintf("you only output prompt for image generate. this is my msg:%s. only output prompt", message),
+		}},
+	})
 	if err != nil {
 		return "", err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status: %d", resp.StatusCode)
-	}
-
-	var grokResp GrokResponse
-	err = json.Unmarshal(body, &grokResp)
-	if err != nil {
-		return "", fmt.Errorf("Error unmarshaling response: %v", err)
-	}
-
-	// Print the response
-	result := "No choices returned in response"
-	if len(grokResp.Choices) > 0 {
-		result = grokResp.Choices[0].Message.Content
-	}
+	result := grokResp.Choices[0].Message.Content
 	log.Println("Grok img prompt:", result)
 	return result, nil
 }
 
-func generateImageByGrok(userMsg string) (string, error) {
-	prompt, err := getImgPromptByGrok(userMsg)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	apiKey := os.Getenv("GROK_API_KEY")
-	if apiKey == "" {
-		log.Fatal("GROK_API_KEY not set in .env")
-	}
-	url := "https://api.x.ai/v1/images/generations"
-
-	// Create request payload
-	reqBody := ImageRequest{
-		Prompt:         prompt,
-		N:              1, // Request 1 image; adjust as needed (max 10)
-		Model:          "grok-2-image-1212",
-		ResponseFormat: "url",
-	}
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %v", err)
-	}
-
-	// Create HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
-
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("error sending request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API request failed: %d, %s", resp.StatusCode, string(body))
-	}
-
-	// Parse response
-	body, err := io.ReadAll(resp.Body)
+func generateImageByGrok(ctx context.Context, model string, userMsg string) (string, error) {
+	promptModel, _ := models.Get().First(models.ProviderGrok, models.KindChat)
+	prompt, err := getImgPromptByGrok(ctx, promptModel.ID, userMsg)
 	if err != nil {
-		return "", fmt.Errorf("error reading response: %v", err)
-	}
-
-	var imgResp ImageResponse
-	err = json.Unmarshal(body, &imgResp)
-	if err != nil {
-		return "", fmt.Errorf("error unmarshaling response: %v", err)
-	}
-
-	// Extract image URLs
-	var imageURLs []string
-	for _, item := range imgResp.Data {
-		imageURLs = append(imageURLs, item.URL)
+		return "", err
 	}
-
-	return imageURLs[0], nil
+	return grok.GenerateImage(ctx, model, prompt)
 }
 
 const maxLength = 4999
@@ -313,165 +122,142 @@ func splitString(input string) []string {
 }
 
 func main() {
+	configPath := flag.String("config", "", "path to the YAML config file (default ./config.yaml if present)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
 
-	// Load environment variables
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// Load environment variables from .env if there is one
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	// Initialize LINE bot client
-	bot, err := messaging_api.NewMessagingApiAPI(
-		os.Getenv("CHANNEL_TOKEN"),
-	)
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	required := path != ""
+	if path == "" {
+		path = "./config.yaml"
+	}
+	loaded, err := config.Load(path, required)
 	if err != nil {
 		log.Fatal(err)
 	}
-	endpointResp, err := bot.GetWebhookEndpoint()
-	if err != nil {
+	cfg = loaded
+	if flag.Arg(0) == "export-feedback" {
+		if err := exportFeedback(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
 		log.Fatal(err)
 	}
+	applyConfig(cfg)
 
-	// only get host and scheme
-	fullUrl, err := url.Parse(endpointResp.Endpoint)
+	// Initialize LINE bot client
+	botOpts := []messaging_api.MessagingApiAPIOption{}
+	if cfg.LINE.APIEndpoint != "" {
+		botOpts = append(botOpts, messaging_api.WithEndpoint(cfg.LINE.APIEndpoint))
+	}
+	bot, err := messaging_api.NewMessagingApiAPI(
+		cfg.LINE.ChannelToken,
+		botOpts...,
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
-	host = fullUrl.Scheme + "://" + fullUrl.Host
+
+	// Image links need the public URL of this server. Without PUBLIC_BASE_URL
+	// it is taken from the webhook endpoint; if LINE can't be reached we start
+	// anyway and Gemini images fail until a later lookup succeeds.
+	if cfg.Server.PublicBaseURL != "" {
+		setPublicBaseURL(cfg.Server.PublicBaseURL)
+	} else {
+		if base, err := lookupPublicBaseURL(bot); err != nil {
+			log.Printf("Starting without a public base URL, image links are disabled: %v", err)
+		} else {
+			setPublicBaseURL(base)
+		}
+		go refreshPublicBaseURL(context.Background(), bot, cfg.Server.PublicBaseURLRefresh)
+	}
 	// Webhook secret for signature validation
-	channelSecret := os.Getenv("CHANNEL_SECRET")
+	channelSecret := cfg.LINE.ChannelSecret
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Set up HTTP server
-	http.HandleFunc("/img/{id}", func(w http.ResponseWriter, r *http.Request) {
+	go geo.Default().Watch(ctx, cfg.GeoIP.ReloadInterval)
+	go saveStateEvery(ctx, stateSaveInterval)
+
+	// Start the server
+	server := &http.Server{
+		Addr:         ":" + cfg.Server.Port,
+		Handler:      newMux(bot, channelSecret),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+	log.Printf("Starting server on port %s", cfg.Server.Port)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGINT or SIGTERM, finish the requests in flight and save what is
+	// only kept in memory between saves.
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.WriteTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down the server: %v", err)
+	}
+	saveState()
+}
+
+// newMux sets up the HTTP routes of the bot.
+func newMux(bot *messaging_api.MessagingApiAPI, channelSecret string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
-		if imgData, found := imgCache.Get(id); found {
+		if imgData, found := st.Image(id); found {
 			w.Header().Set("Content-Type", "image/png")
-			w.Write(imgData.([]byte))
+			w.Write(imgData)
 		} else {
 			http.NotFound(w, r)
 		}
 	})
-	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-		// Parse incoming LINE webhook request
-		cb, err := webhook.ParseRequest(channelSecret, r)
-		if err != nil {
-			if err == linebot.ErrInvalidSignature {
-				w.WriteHeader(400)
-			} else {
-				w.WriteHeader(500)
-			}
-			log.Printf("Error parsing request: %v", err)
-			return
-		}
-		chatID := cb.Destination
-		fmt.Println(chatID)
-		// Process each event
-		for _, event := range cb.Events {
-			switch e := event.(type) {
-			case webhook.MessageEvent:
-				switch msg := e.Message.(type) {
-				case webhook.TextMessageContent:
-					thisText := strings.TrimSpace(msg.Text)
-					// Check if message starts with "AI@"
-					if strings.HasPrefix(strings.ToLower(thisText), strings.ToLower("AI@")) {
-						// Extract the message content after "AI@"
-						userMsg := strings.TrimSpace(strings.TrimPrefix(strings.ToLower(thisText), strings.ToLower("AI@")))
-						if userMsg == "" {
-							continue // Skip if no content after "AI@"
-						}
+	mux.HandleFunc("/export/{id}", exportRoute)
+	mux.HandleFunc("/callback", callbackHandler(bot, channelSecret))
 
-						ip := utils.GetClientIP(r)
-						if ip == "" {
-							http.Error(w, "Could not determine client IP", http.StatusBadRequest)
-							log.Println("Could not determine client IP")
-							return
-						}
-						location := utils.GetLocationByIP(ip)
+	// The chat routes spend provider credits, so they need an API key
+	mux.HandleFunc("/grok/chat", apiRoute(scopeGrok, ratelimit.KindChat, grok.GrokRoute))
 
-						// Call Grok API
-						response, err := gemini.GenerateByGeminiWithSearch(userMsg, location)
-						if err != nil {
-							log.Printf("Error calling Grok API: %v", err)
-							response = "Sorry, I couldn't process your request."
-						}
+	mux.HandleFunc("/gemini/chat", apiRoute(scopeGemini, ratelimit.KindSearch, gemini.GeminiRoute))
 
-						responseSlices := splitString(response)
+	// Monthly token usage and cost, for the bot admin
+	mux.HandleFunc("GET /admin/usage", requireAdmin(adminUsageRoute))
 
-						replyMsg := []messaging_api.MessageInterface{}
-
-						for _, responseM := range responseSlices {
-							replyMsg = append(replyMsg, &messaging_api.TextMessage{
-								Text: responseM,
-							})
-						}
-
-						// Reply to the user via LINE
-						_, err = bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
-							ReplyToken: e.ReplyToken,
-							Messages:   replyMsg,
-						})
-						if err != nil {
-							log.Printf("Error replying to message: %v", err)
-						}
-					} else if strings.HasPrefix(strings.ToLower(thisText), strings.ToLower("AI#")) {
-						// Extract the message content after "AI@"
-						grokMsg := strings.TrimSpace(strings.TrimPrefix(strings.ToLower(thisText), strings.ToLower("AI#")))
-						if grokMsg == "" {
-							continue // Skip if no content after "AI@"
-						}
-						useGrok := false
-						var response string
-						if grokMsg[0] == '#' {
-							grokMsg = grokMsg[1:] // remove the first character
-							useGrok = true
-						}
-						fmt.Println("Use Grok:", useGrok)
-						if useGrok {
-							// Call Grok API
-							response, err = generateImageByGrok(grokMsg)
-							if err != nil {
-								log.Printf("Error calling Grok API: %v", err)
-								continue
-							}
-						} else {
-							// Call Grok API
-							response, err = gemini.GenerateImageByGemini(host, grokMsg)
-							if err != nil {
-								log.Printf("Error calling Gemini API: %v", err)
-								continue
-							}
-						}
-
-						// Reply to the user via LINE
-						_, err = bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
-							ReplyToken: e.ReplyToken,
-							Messages: []messaging_api.MessageInterface{
-								&messaging_api.ImageMessage{
-									OriginalContentUrl: response,
-									PreviewImageUrl:    response,
-								},
-							},
-						})
-						if err != nil {
-							log.Printf("Error replying to message: %v", err)
-						}
-					}
-				}
-			}
-		}
+	// Circuit breaker state of each provider and whether image links work
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"providers":     provider.Breakers(),
+			"publicBaseURL": publicBaseURL(),
+			"degraded":      publicBaseURL() == "",
+			"geoip":         geo.Default().Available(),
+		})
 	})
-
-	http.HandleFunc("/grok/chat", grok.GrokRoute)
-
-	http.HandleFunc("/gemini/chat", gemini.GeminiRoute)
-
-	// Start the server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Printf("Starting server on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
-	}
+	return mux
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"linebot-grok/config"
+	"linebot-grok/grok"
+	"linebot-grok/linetest"
+	"linebot-grok/mockprovider"
+	"linebot-grok/models"
+	"linebot-grok/provider"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-channel-secret"
+
+// builtinCatalog is the model catalog before any test changed it.
+var builtinCatalog = models.Get()
+
+// testBot is the bot wired to the mock providers and a fake Messaging API.
+type testBot struct {
+	mock *mockprovider.Server
+	line *linetest.API
+	mux  *http.ServeMux
+}
+
+// newTestBot resets the bot's state with a config for tests: no rate
+// limits and both providers on the mock.
+func newTestBot(t *testing.T) *testBot {
+	t.Helper()
+	mock := mockprovider.New()
+	t.Cleanup(mock.Close)
+	line := linetest.NewAPI()
+	t.Cleanup(line.Close)
+
+	catalog := builtinCatalog
+	conf := config.Default()
+	conf.LINE.ChannelSecret = testSecret
+	conf.LINE.ChannelToken = linetest.Token
+	conf.Grok.APIKey = mockprovider.APIKey
+	conf.Gemini.APIKey = mockprovider.APIKey
+	conf.Limits.Chat = ratelimit.Policy{}
+	conf.Limits.Search = ratelimit.Policy{}
+	conf.Limits.Image = ratelimit.Policy{}
+	conf.Models = &catalog
+	dir := t.TempDir()
+	conf.Server.APIKeysFile = filepath.Join(dir, "api_keys.json")
+	conf.Feedback.File = filepath.Join(dir, "feedback.jsonl")
+	conf.Limits.File = filepath.Join(dir, "quotas.json")
+	cfg = conf
+	applyConfig(cfg)
+	mock.Configure()
+	setPublicBaseURL("https://bot.example.com")
+
+	return &testBot{mock: mock, line: line, mux: newMux(line.Client(), testSecret)}
+}
+
+// send delivers a signed webhook with ev and returns the bot's reply to it.
+func (b *testBot) send(t *testing.T, ev linetest.Event) linetest.Reply {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	b.mux.ServeHTTP(rec, linetest.NewRequest(testSecret, ev))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/callback answered %d: %s", rec.Code, rec.Body)
+	}
+	reply, _ := b.line.ReplyTo(ev.ReplyToken())
+	return reply
+}
+
+// issueKey adds an API key with scopes and returns it.
+func (b *testBot) issueKey(t *testing.T, scopes ...string) string {
+	t.Helper()
+	id, key := newAPIKey()
+	st.AddAPIKey(store.APIKey{ID: id, Name: "test", Hash: store.HashKey(key), Scopes: scopes, Created: time.Now()})
+	return key
+}
+
+// post calls a chat route with key.
+func (b *testBot) post(path string, key string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	b.mux.ServeHTTP(rec, r)
+	return rec
+}
+
+const grokBody = `[{"role":"user","content":"What is 101*3?"}]`
+const geminiBody = `{"content":"What is 101*3?"}`
+
+func TestCallbackAnswersFromMock(t *testing.T) {
+	b := newTestBot(t)
+	reply := b.send(t, linetest.Text(linetest.User("U1"), "AI@ hello there"))
+	if texts := reply.Texts(); len(texts) != 1 || !strings.HasPrefix(texts[0], "mock reply: hello there") {
+		t.Errorf("reply = %q", texts)
+	}
+	if len(b.mock.Requests()) == 0 {
+		t.Error("the mock got no requests")
+	}
+}
+
+func TestCallbackRejectsBadSignature(t *testing.T) {
+	b := newTestBot(t)
+	r := linetest.NewRequest("wrong-secret", linetest.Text(linetest.User("U1"), "AI@ hello"))
+	rec := httptest.NewRecorder()
+	b.mux.ServeHTTP(rec, r)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+	if len(b.line.Replies()) != 0 || len(b.mock.Requests()) != 0 {
+		t.Error("a request with a bad signature was processed")
+	}
+}
+
+func TestCallbackFallsBackWhenProviderIsDown(t *testing.T) {
+	b := newTestBot(t)
+	b.mock.Fail(
+		mockprovider.Failure{Provider: "gemini", Status: http.StatusServiceUnavailable},
+		mockprovider.Failure{Provider: "gemini", Status: http.StatusServiceUnavailable},
+		mockprovider.Failure{Provider: "gemini", Status: http.StatusServiceUnavailable},
+	)
+	reply := b.send(t, linetest.Text(linetest.User("U1"), "AI@ hello"))
+	if texts := reply.Texts(); len(texts) != 1 || texts[0] != "mock reply: hello" {
+		t.Errorf("reply = %q, want the Grok answer", texts)
+	}
+	if ex, _ := st.LastExchange("user:U1"); ex.Model != "grok" {
+		t.Errorf("answered by %q, want grok", ex.Model)
+	}
+}
+
+func TestCallbackBlockedAnswer(t *testing.T) {
+	b := newTestBot(t)
+	b.mock.Fail(mockprovider.Failure{Provider: "gemini", Blocked: true})
+	reply := b.send(t, linetest.Text(linetest.User("U1"), "AI@ something unsafe"))
+	if texts := reply.Texts(); len(texts) != 1 || texts[0] != friendlyError("user:U1", provider.New("gemini", provider.KindSafety, "blocked")) {
+		t.Errorf("reply = %q, want the safety message", texts)
+	}
+}
+
+func TestGrokChatRoute(t *testing.T) {
+	b := newTestBot(t)
+	rec := b.post("/grok/chat", b.issueKey(t, scopeGrok), grokBody)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp grok.GrokCompletionsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "mock reply: What is 101*3?" {
+		t.Errorf("response = %s", rec.Body)
+	}
+}
+
+func TestGeminiChatRoute(t *testing.T) {
+	b := newTestBot(t)
+	b.mock.Sources(mockprovider.Source{Title: "Calculator", URI: "https://calc.example.com/"})
+	rec := b.post("/gemini/chat", b.issueKey(t, scopeGemini), geminiBody)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Response string `json:"response"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Response, "mock reply: What is 101*3?") || !strings.Contains(resp.Response, "https://calc.example.com/") {
+		t.Errorf("response = %q", resp.Response)
+	}
+}
+
+func TestChatRoutesNeedKeys(t *testing.T) {
+	b := newTestBot(t)
+	grokKey := b.issueKey(t, scopeGrok)
+	for _, tc := range []struct {
+		name string
+		path string
+		key  string
+		want int
+	}{
+		{"grok without key", "/grok/chat", "", http.StatusUnauthorized},
+		{"gemini without key", "/gemini/chat", "", http.StatusUnauthorized},
+		{"unknown key", "/grok/chat", "lbk_nope_nope", http.StatusUnauthorized},
+		{"key without scope", "/gemini/chat", grokKey, http.StatusForbidden},
+	} {
+		if rec := b.post(tc.path, tc.key, grokBody); rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+	if n := len(b.mock.Requests()); n != 0 {
+		t.Errorf("rejected requests reached the provider %d times", n)
+	}
+}
+
+func TestChatRoutesProviderFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		failures   []mockprovider.Failure
+		want       int
+		retryAfter string
+	}{
+		{
+			name:     "recovers after retries",
+			failures: []mockprovider.Failure{{Status: 503}, {Status: 500}},
+			want:     http.StatusOK,
+		},
+		{
+			name:     "down",
+			failures: []mockprovider.Failure{{Status: 503}, {Status: 503}, {Status: 503}},
+			want:     http.StatusServiceUnavailable,
+		},
+		{
+			name:       "rate limited for long",
+			failures:   []mockprovider.Failure{{Status: 429, RetryAfter: "120"}},
+			want:       http.StatusTooManyRequests,
+			retryAfter: "120",
+		},
+		{
+			name:     "our key rejected",
+			failures: []mockprovider.Failure{{Status: 401, Body: `{"error":"invalid api key"}`}},
+			want:     http.StatusBadGateway,
+		},
+		{
+			name:     "blocked",
+			failures: []mockprovider.Failure{{Blocked: true}},
+			want:     http.StatusUnprocessableEntity,
+		},
+	} {
+		for _, route := range []struct{ path, scope, body string }{
+			{"/grok/chat", scopeGrok, grokBody},
+			{"/gemini/chat", scopeGemini, geminiBody},
+		} {
+			t.Run(tc.name+" "+route.path, func(t *testing.T) {
+				b := newTestBot(t)
+				b.mock.Fail(tc.failures...)
+				rec := b.post(route.path, b.issueKey(t, route.scope), route.body)
+				if rec.Code != tc.want {
+					t.Errorf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+				}
+				if got := rec.Header().Get("Retry-After"); got != tc.retryAfter {
+					t.Errorf("Retry-After = %q, want %q", got, tc.retryAfter)
+				}
+			})
+		}
+	}
+}
+
+func TestChatRouteTimeout(t *testing.T) {
+	b := newTestBot(t)
+	grok.Configure(grok.Options{
+		APIKey:  mockprovider.APIKey,
+		BaseURL: b.mock.GrokURL(),
+		Timeout: 50 * time.Millisecond,
+		Retry:   provider.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	})
+	b.mock.Fail(
+		mockprovider.Failure{Provider: "grok", Delay: time.Second},
+		mockprovider.Failure{Provider: "grok", Delay: time.Second},
+	)
+	rec := b.post("/grok/chat", b.issueKey(t, scopeGrok), grokBody)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDailyQuotasSurviveRestart(t *testing.T) {
+	b := newTestBot(t)
+	// The default chat model searches, so questions count as searches.
+	cfg.Limits.Search = ratelimit.Policy{Daily: 2}
+	applyConfig(cfg)
+	b.mock.Configure()
+	for range 2 {
+		if texts := b.send(t, linetest.Text(linetest.User("U1"), "AI@ hello")).Texts(); !strings.HasPrefix(texts[0], "mock reply: hello") {
+			t.Fatalf("reply = %q", texts)
+		}
+	}
+	saveState()
+
+	// applyConfig starts the bot's state afresh, as a restart does.
+	applyConfig(cfg)
+	b.mock.Configure()
+	if used, daily := limiter.Usage(ratelimit.KindSearch, "line:U1"); used != 2 || daily != 2 {
+		t.Errorf("usage after restart = %d of %d", used, daily)
+	}
+	if texts := b.send(t, linetest.Text(linetest.User("U1"), "AI@ hello")).Texts(); len(texts) != 1 || strings.HasPrefix(texts[0], "mock reply") {
+		t.Errorf("reply over the quota = %q", texts)
+	}
+	if texts := b.send(t, linetest.Text(linetest.User("U2"), "AI@ hello")).Texts(); len(texts) != 1 || !strings.HasPrefix(texts[0], "mock reply: hello") {
+		t.Errorf("another user's reply = %q", texts)
+	}
+}
+
+func TestStatusRoute(t *testing.T) {
+	b := newTestBot(t)
+	rec := httptest.NewRecorder()
+	b.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"publicBaseURL":"https://bot.example.com"`) {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,127 @@
+package mockprovider
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// geminiRequest accepts both the REST field names and the snake_case ones
+// the search call uses.
+type geminiRequest struct {
+	Contents []struct {
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	} `json:"contents"`
+	Tools []struct {
+		GoogleSearch      *struct{} `json:"googleSearch"`
+		GoogleSearchSnake *struct{} `json:"google_search"`
+	} `json:"tools"`
+	GenerationConfig struct {
+		ResponseModalities []string `json:"responseModalities"`
+		ResponseMIMEType   string   `json:"responseMimeType"`
+	} `json:"generationConfig"`
+}
+
+func (req geminiRequest) search() bool {
+	for _, t := range req.Tools {
+		if t.GoogleSearch != nil || t.GoogleSearchSnake != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func geminiKey(r *http.Request) string {
+	if key := r.URL.Query().Get("key"); key != "" {
+		return key
+	}
+	return r.Header.Get("x-goog-api-key")
+}
+
+// geminiCall serves models/{model}:generateContent and :streamGenerateContent.
+func (s *Server) geminiCall(w http.ResponseWriter, r *http.Request) {
+	_, method, _ := strings.Cut(r.PathValue("call"), ":")
+	if method != "generateContent" && method != "streamGenerateContent" {
+		http.NotFound(w, r)
+		return
+	}
+	body, failure, ok := s.receive(w, r, "gemini", geminiKey(r))
+	if !ok {
+		return
+	}
+	var req geminiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, `{"error":{"code":400,"message":"invalid JSON"}}`, http.StatusBadRequest)
+		return
+	}
+
+	if failure != nil && failure.Blocked {
+		writeJSON(w, map[string]any{
+			"candidates": []any{map[string]any{"finishReason": "SAFETY"}},
+		})
+		return
+	}
+
+	question := ""
+	if n := len(req.Contents); n > 0 && len(req.Contents[n-1].Parts) > 0 {
+		question = req.Contents[n-1].Parts[0].Text
+	}
+
+	var parts []any
+	switch {
+	case slices.Contains(req.GenerationConfig.ResponseModalities, "IMAGE"):
+		parts = []any{map[string]any{"inlineData": map[string]any{"mimeType": "image/png", "data": PNG}}}
+	case req.GenerationConfig.ResponseMIMEType == "application/json":
+		parts = []any{map[string]string{"text": `["Tell me more","Why?","Any examples?"]`}}
+	default:
+		parts = []any{map[string]string{"text": s.answer(question)}}
+	}
+
+	candidate := map[string]any{
+		"content":      map[string]any{"role": "model", "parts": parts},
+		"finishReason": "STOP",
+	}
+	if req.search() {
+		s.mu.Lock()
+		chunks := []any{}
+		for _, src := range s.sources {
+			chunks = append(chunks, map[string]any{"web": map[string]string{"title": src.Title, "uri": src.URI}})
+		}
+		s.mu.Unlock()
+		candidate["groundingMetadata"] = map[string]any{
+			"webSearchQueries": []string{question},
+			"groundingChunks":  chunks,
+		}
+	}
+
+	usage := map[string]int{
+		"promptTokenCount":     len(strings.Fields(question)),
+		"candidatesTokenCount": len(strings.Fields(s.answer(question))),
+	}
+	usage["totalTokenCount"] = usage["promptTokenCount"] + usage["candidatesTokenCount"]
+
+	if method == "streamGenerateContent" {
+		chunks := []any{}
+		text := s.answer(question)
+		for i, w := range words(text) {
+			c := map[string]any{"content": map[string]any{"role": "model", "parts": []any{map[string]string{"text": w}}}}
+			if i == len(words(text))-1 {
+				c["finishReason"] = "STOP"
+				if meta, ok := candidate["groundingMetadata"]; ok {
+					c["groundingMetadata"] = meta
+				}
+			}
+			chunk := map[string]any{"candidates": []any{c}}
+			if i == len(words(text))-1 {
+				chunk["usageMetadata"] = usage
+			}
+			chunks = append(chunks, chunk)
+		}
+		writeEvents(w, chunks, false)
+		return
+	}
+	writeJSON(w, map[string]any{"candidates": []any{candidate}, "usageMetadata": usage})
+}
@@ -0,0 +1,93 @@
+package mockprovider
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type grokRequest struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+	// ResponseFormat is set when structured output is asked for.
+	ResponseFormat *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+}
+
+func grokKey(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func (s *Server) grokChat(w http.ResponseWriter, r *http.Request) {
+	body, failure, ok := s.receive(w, r, "grok", grokKey(r))
+	if !ok {
+		return
+	}
+	var req grokRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, `{"error":"invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	question := ""
+	for _, m := range req.Messages {
+		if m.Role == "user" {
+			question = m.Content
+		}
+	}
+	answer := s.answer(question)
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_schema" {
+		answer = `{"questions":["Tell me more","Why?","Any examples?"]}`
+	}
+	finish := "stop"
+	if failure != nil && failure.Blocked {
+		answer, finish = "", "content_filter"
+	}
+
+	if req.Stream {
+		chunks := []any{}
+		for _, w := range words(answer) {
+			chunks = append(chunks, map[string]any{
+				"model":   req.Model,
+				"choices": []any{map[string]any{"index": 0, "delta": map[string]string{"role": "assistant", "content": w}}},
+			})
+		}
+		chunks = append(chunks, map[string]any{
+			"model":   req.Model,
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]string{}, "finish_reason": finish}},
+		})
+		writeEvents(w, chunks, true)
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"id":      "mock",
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   req.Model,
+		"choices": []any{map[string]any{
+			"index":         0,
+			"finish_reason": finish,
+			"message":       map[string]string{"role": "assistant", "content": answer},
+		}},
+		"usage": map[string]int{
+			"prompt_tokens":     len(strings.Fields(question)),
+			"completion_tokens": len(strings.Fields(answer)),
+			"total_tokens":      len(strings.Fields(question)) + len(strings.Fields(answer)),
+		},
+	})
+}
+
+func (s *Server) grokImage(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := s.receive(w, r, "grok", grokKey(r)); !ok {
+		return
+	}
+	writeJSON(w, map[string]any{
+		"data": []any{map[string]string{"url": s.URL + "/image.png"}},
+	})
+}
@@ -0,0 +1,221 @@
+// Package mockprovider is a local stand-in for the xAI and Gemini APIs, so the
+// bot and its HTTP routes can run without network access or API keys.
+//
+//	m := mockprovider.New()
+//	defer m.Close()
+//	m.Configure()
+//	m.Reply("Hello from the mock")
+//	m.Fail(mockprovider.Failure{Provider: "gemini", Status: 503})
+package mockprovider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"linebot-grok/gemini"
+	"linebot-grok/grok"
+	"linebot-grok/provider"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey is the key the mock expects from both providers.
+const APIKey = "mock-api-key"
+
+// PNG is the 1x1 image returned for image requests.
+var PNG, _ = base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+
+// Source is a search result returned with search-grounded Gemini answers.
+type Source struct {
+	Title string
+	URI   string
+}
+
+// Failure makes the next matching request fail.
+type Failure struct {
+	// Provider is "grok", "gemini" or empty for either.
+	Provider string
+	// Status is the HTTP status to answer with. Zero with Blocked set answers 200.
+	Status int
+	Body   string
+	// RetryAfter is sent as the Retry-After header when set.
+	RetryAfter string
+	// Blocked answers 200 with a safety finish reason instead of an error status.
+	Blocked bool
+	// Delay holds the response back, e.g. to trigger client timeouts.
+	Delay time.Duration
+}
+
+// Request is a call received by the mock.
+type Request struct {
+	Provider string
+	Path     string
+	Body     []byte
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	reply    string
+	sources  []Source
+	failures []Failure
+	requests []Request
+}
+
+// New starts a mock server. Close it when done.
+func New() *Server {
+	s := &Server{
+		sources: []Source{{Title: "example.com", URI: "https://example.com/"}},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /grok/v1/chat/completions", s.grokChat)
+	mux.HandleFunc("POST /grok/v1/images/generations", s.grokImage)
+	mux.HandleFunc("POST /gemini/{version}/models/{call}", s.geminiCall)
+	mux.HandleFunc("GET /image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(PNG)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// GrokURL is the base URL to configure the Grok provider with.
+func (s *Server) GrokURL() string {
+	return s.URL + "/grok/v1"
+}
+
+// GeminiURL is the base URL to configure the Gemini provider with.
+func (s *Server) GeminiURL() string {
+	return s.URL + "/gemini"
+}
+
+// Configure points both providers at the mock, with retries that don't wait.
+func (s *Server) Configure() {
+	retry := provider.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	gemini.Configure(gemini.Options{APIKey: APIKey, BaseURL: s.GeminiURL(), Timeout: 5 * time.Second, Retry: retry})
+	grok.Configure(grok.Options{APIKey: APIKey, BaseURL: s.GrokURL(), Timeout: 5 * time.Second, Retry: retry})
+}
+
+// Reply sets the text of every chat answer. When empty, answers echo the
+// last user message as "mock reply: <message>".
+func (s *Server) Reply(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reply = text
+}
+
+// Sources sets the search results of search-grounded answers.
+func (s *Server) Sources(sources ...Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = sources
+}
+
+// Fail queues failures; each one is used by a single request.
+func (s *Server) Fail(failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failures...)
+}
+
+// Requests returns the calls received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Reset forgets the received calls, queued failures and the custom reply.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reply = ""
+	s.failures = nil
+	s.requests = nil
+}
+
+// receive records the request and returns its body and the failure to
+// apply, if any. It writes the error response itself when the key is wrong.
+func (s *Server) receive(w http.ResponseWriter, r *http.Request, name string, key string) ([]byte, *Failure, bool) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Provider: name, Path: r.URL.Path, Body: body})
+	var failure *Failure
+	for i, f := range s.failures {
+		if f.Provider == "" || f.Provider == name {
+			failure = &f
+			s.failures = append(s.failures[:i:i], s.failures[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if key != APIKey {
+		http.Error(w, `{"error":{"code":401,"message":"invalid api key"}}`, http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if failure != nil {
+		if failure.Delay > 0 {
+			select {
+			case <-time.After(failure.Delay):
+			case <-r.Context().Done():
+				return nil, nil, false
+			}
+		}
+		if failure.Status != 0 && !failure.Blocked {
+			if failure.RetryAfter != "" {
+				w.Header().Set("Retry-After", failure.RetryAfter)
+			}
+			http.Error(w, failure.Body, failure.Status)
+			return nil, nil, false
+		}
+	}
+	return body, failure, true
+}
+
+func (s *Server) answer(question string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reply != "" {
+		return s.reply
+	}
+	return "mock reply: " + question
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeEvents streams each chunk as a server-sent event.
+func writeEvents(w http.ResponseWriter, chunks []any, done bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for _, c := range chunks {
+		data, _ := json.Marshal(c)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if done {
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+}
+
+// words splits text into streaming chunks, keeping the spaces.
+func words(text string) []string {
+	chunks := []string{}
+	for _, w := range strings.SplitAfter(text, " ") {
+		if w != "" {
+			chunks = append(chunks, w)
+		}
+	}
+	return chunks
+}
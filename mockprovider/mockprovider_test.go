@@ -0,0 +1,113 @@
+package mockprovider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// The bot doesn't stream answers yet; these check that the mock's streams
+// decode the way the providers' do, for when it does.
+func TestStreamsGrok(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.Reply("streamed in four words")
+	req, _ := http.NewRequest(http.MethodPost, s.GrokURL()+"/chat/completions",
+		strings.NewReader(`{"model":"grok-3-beta","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Authorization", "Bearer "+APIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var text, finish string
+	done := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta        struct{ Content string } `json:"delta"`
+				FinishReason string                   `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("chunk %q: %v", data, err)
+		}
+		text += chunk.Choices[0].Delta.Content
+		if chunk.Choices[0].FinishReason != "" {
+			finish = chunk.Choices[0].FinishReason
+		}
+	}
+	if text != "streamed in four words" || finish != "stop" || !done {
+		t.Errorf("stream gave %q, finish %q, done %v", text, finish, done)
+	}
+}
+
+func TestStreamsGemini(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.Reply("streamed in four words")
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		APIKey:      APIKey,
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: s.GeminiURL() + "/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	chunks := 0
+	for resp, err := range client.Models.GenerateContentStream(context.Background(), "gemini-2.0-flash", genai.Text("hi"), nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		text.WriteString(resp.Text())
+		chunks++
+	}
+	if text.String() != "streamed in four words" || chunks != 4 {
+		t.Errorf("stream gave %q in %d chunks", text.String(), chunks)
+	}
+}
+
+func TestFailuresAreUsedOnce(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.Fail(Failure{Provider: "grok", Status: http.StatusServiceUnavailable, RetryAfter: "3"})
+	post := func() *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, s.GrokURL()+"/chat/completions",
+			strings.NewReader(`{"model":"grok-3-beta","messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Authorization", "Bearer "+APIKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post(); resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "3" {
+		t.Errorf("first call = %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp := post(); resp.StatusCode != http.StatusOK {
+		t.Errorf("second call = %d, want 200", resp.StatusCode)
+	}
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("recorded %d requests, want 2", n)
+	}
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ProviderGemini = "gemini"
+	ProviderGrok   = "grok"
+
+	KindChat  = "chat"
+	KindImage = "image"
+)
+
+// Model is an entry of the catalog. Name is what users type in "AI model <name>",
+// ID is the model name sent to the provider API.
+type Model struct {
+	Name        string `json:"name" yaml:"name"`
+	Provider    string `json:"provider" yaml:"provider"`
+	ID          string `json:"id" yaml:"id"`
+	Kind        string `json:"kind" yaml:"kind"`
+	Search      bool   `json:"search,omitempty" yaml:"search,omitempty"` // Gemini Google Search grounding
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// ContextTokens is how many tokens of system prompt, history and question
+	// are sent to a chat model; older history is dropped to stay within it.
+	ContextTokens int `json:"contextTokens,omitempty" yaml:"contextTokens,omitempty"`
+}
+
+// DefaultContextTokens is the budget of chat models that don't set one.
+const DefaultContextTokens = 8000
+
+// ContextBudget returns the model's context token budget.
+func (m Model) ContextBudget() int {
+	if m.ContextTokens > 0 {
+		return m.ContextTokens
+	}
+	return DefaultContextTokens
+}
+
+// Catalog lists the models a conversation may choose from.
+type Catalog struct {
+	Models []Model `json:"models" yaml:"models"`
+	// Defaults used when a conversation hasn't picked a model.
+	DefaultChat  string `json:"defaultChat" yaml:"defaultChat"`
+	DefaultImage string `json:"defaultImage" yaml:"defaultImage"`
+	// Utility is the cheap chat model used for summaries, translations and
+	// suggestions. It may be on either provider.
+	Utility string `json:"utility" yaml:"utility"`
+	// Fallback lists the chat models tried, in order, when the chosen one's provider is down.
+	Fallback []string `json:"fallback,omitempty" yaml:"fallback,omitempty"`
+}
+
+var builtin = Catalog{
+	Models: []Model{
+		{Name: "gemini", Provider: ProviderGemini, ID: "gemini-2.0-flash", Kind: KindChat, Search: true, Description: "Gemini with Google Search", ContextTokens: 32000},
+		{Name: "gemini-flash", Provider: ProviderGemini, ID: "gemini-2.0-flash", Kind: KindChat, Description: "Gemini without search", ContextTokens: 32000},
+		{Name: "grok", Provider: ProviderGrok, ID: "grok-3-beta", Kind: KindChat, Description: "Grok", ContextTokens: 16000},
+		{Name: "gemini-image", Provider: ProviderGemini, ID: "gemini-2.0-flash-exp-image-generation", Kind: KindImage, Description: "Gemini image generation"},
+		{Name: "grok-image", Provider: ProviderGrok, ID: "grok-2-image-1212", Kind: KindImage, Description: "Grok image generation"},
+	},
+	DefaultChat:  "gemini",
+	DefaultImage: "gemini-image",
+	Utility:      "gemini-flash",
+	Fallback:     []string{"gemini", "grok", "gemini-flash"},
+}
+
+var (
+	mu      sync.RWMutex
+	current = builtin
+)
+
+// Set validates and installs c as the current catalog.
+func Set(c Catalog) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	current = c
+	return nil
+}
+
+func Get() Catalog {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Validate reports every problem with the catalog at once.
+func (c Catalog) Validate() error {
+	problems := []string{}
+	seen := map[string]bool{}
+	for i, m := range c.Models {
+		if m.Name == "" {
+			problems = append(problems, fmt.Sprintf("models[%d]: name is required", i))
+		} else if seen[m.Name] {
+			problems = append(problems, fmt.Sprintf("models[%d]: duplicate name %q", i, m.Name))
+		}
+		seen[m.Name] = true
+		if m.ID == "" {
+			problems = append(problems, fmt.Sprintf("models[%d]: id is required", i))
+		}
+		if m.Provider != ProviderGemini && m.Provider != ProviderGrok {
+			problems = append(problems, fmt.Sprintf("models[%d]: unknown provider %q", i, m.Provider))
+		}
+		if m.Kind != KindChat && m.Kind != KindImage {
+			problems = append(problems, fmt.Sprintf("models[%d]: unknown kind %q", i, m.Kind))
+		}
+		if m.ContextTokens < 0 {
+			problems = append(problems, fmt.Sprintf("models[%d]: contextTokens must not be negative", i))
+		}
+	}
+	for _, d := range []struct{ field, name, kind string }{
+		{"defaultChat", c.DefaultChat, KindChat},
+		{"defaultImage", c.DefaultImage, KindImage},
+		{"utility", c.Utility, KindChat},
+	} {
+		if m, ok := c.Lookup(d.name); !ok || m.Kind != d.kind {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a %s model in the catalog", d.field, d.name, d.kind))
+		}
+	}
+	for i, name := range c.Fallback {
+		if m, ok := c.Lookup(name); !ok || m.Kind != KindChat {
+			problems = append(problems, fmt.Sprintf("fallback[%d]: %q is not a chat model in the catalog", i, name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid model catalog:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (c Catalog) Lookup(name string) (Model, bool) {
+	for _, m := range c.Models {
+		if strings.EqualFold(m.Name, name) {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// Resolve returns the model called name if it exists and is of the given kind,
+// and the catalog default for that kind otherwise.
+func (c Catalog) Resolve(name string, kind string) Model {
+	if m, ok := c.Lookup(name); ok && m.Kind == kind {
+		return m
+	}
+	def := c.DefaultChat
+	if kind == KindImage {
+		def = c.DefaultImage
+	}
+	m, _ := c.Lookup(def)
+	return m
+}
+
+// First returns the first model of the provider and kind.
+func (c Catalog) First(provider string, kind string) (Model, bool) {
+	for _, m := range c.Models {
+		if m.Provider == provider && m.Kind == kind {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// Names lists the names of all models of a kind, sorted.
+func (c Catalog) Names(kind string) []string {
+	names := []string{}
+	for _, m := range c.Models {
+		if m.Kind == kind {
+			names = append(names, m.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UtilityModel returns the model used for summaries, translations and suggestions.
+func (c Catalog) UtilityModel() Model {
+	return c.Resolve(c.Utility, KindChat)
+}
+
+// Chain returns first followed by the fallback models, without repeats.
+func (c Catalog) Chain(first Model) []Model {
+	chain := []Model{first}
+	for _, name := range c.Fallback {
+		m, ok := c.Lookup(name)
+		if !ok || m.Kind != first.Kind || slices.ContainsFunc(chain, func(x Model) bool { return x.Name == m.Name }) {
+			continue
+		}
+		chain = append(chain, m)
+	}
+	return chain
+}
@@ -0,0 +1,114 @@
+package postback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+/*
+Postback data is a compact, signed string:
+
+	v1|<action>|<conversation ref>|<arg1>,<arg2>,...|<signature>
+
+Args are path-escaped so they can't contain the separators. The signature
+is a truncated HMAC-SHA256 over everything before it, so a client can't
+change the action, the args or replay a button into another conversation.
+LINE limits postback data to 300 characters.
+*/
+
+const version = "v1"
+const sigBytes = 12
+const MaxDataLength = 300
+
+var (
+	ErrMalformed    = errors.New("postback: malformed payload")
+	ErrBadSignature = errors.New("postback: invalid signature")
+	ErrTooLong      = errors.New("postback: payload exceeds 300 characters")
+)
+
+// Payload is what a button asks the bot to do.
+type Payload struct {
+	Action string
+	Ref    string
+	Args   []string
+}
+
+func (p Payload) Arg(i int) string {
+	if i < len(p.Args) {
+		return p.Args[i]
+	}
+	return ""
+}
+
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Encode serializes and signs p.
+func (s *Signer) Encode(p Payload) (string, error) {
+	args := make([]string, len(p.Args))
+	for i, a := range p.Args {
+		args[i] = url.PathEscape(a)
+	}
+	body := strings.Join([]string{
+		version,
+		url.PathEscape(p.Action),
+		url.PathEscape(p.Ref),
+		strings.Join(args, ","),
+	}, "|")
+	data := body + "|" + s.sign(body)
+	if len(data) > MaxDataLength {
+		return "", ErrTooLong
+	}
+	return data, nil
+}
+
+// Decode verifies the signature and parses the payload.
+func (s *Signer) Decode(data string) (Payload, error) {
+	idx := strings.LastIndex(data, "|")
+	if idx < 0 {
+		return Payload{}, ErrMalformed
+	}
+	body, sig := data[:idx], data[idx+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.sign(body))) {
+		return Payload{}, ErrBadSignature
+	}
+
+	fields := strings.Split(body, "|")
+	if len(fields) != 4 || fields[0] != version {
+		return Payload{}, ErrMalformed
+	}
+	action, err := url.PathUnescape(fields[1])
+	if err != nil {
+		return Payload{}, ErrMalformed
+	}
+	ref, err := url.PathUnescape(fields[2])
+	if err != nil {
+		return Payload{}, ErrMalformed
+	}
+	p := Payload{Action: action, Ref: ref}
+	if fields[3] != "" {
+		for _, a := range strings.Split(fields[3], ",") {
+			arg, err := url.PathUnescape(a)
+			if err != nil {
+				return Payload{}, ErrMalformed
+			}
+			p.Args = append(p.Args, arg)
+		}
+	}
+	return p, nil
+}
+
+func (s *Signer) sign(body string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:sigBytes])
+}
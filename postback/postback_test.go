@@ -0,0 +1,125 @@
+package postback
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	s := NewSigner("secret")
+	for _, p := range []Payload{
+		{Action: "regen", Ref: "group:C1234"},
+		{Action: "rate", Ref: "user:U1", Args: []string{"up", "a1b2c3"}},
+		{Action: "model", Ref: "room:R1", Args: []string{"gemini-flash"}},
+		{Action: "regen", Ref: "user:U1", Args: []string{"", "grok"}},
+	} {
+		data, err := s.Encode(p)
+		if err != nil {
+			t.Fatalf("Encode(%+v): %v", p, err)
+		}
+		got, err := s.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", data, err)
+		}
+		if !reflect.DeepEqual(got, p) {
+			t.Errorf("round trip of %+v gave %+v", p, got)
+		}
+	}
+}
+
+func TestSeparatorsInArgs(t *testing.T) {
+	s := NewSigner("secret")
+	p := Payload{
+		Action: "a|b",
+		Ref:    "group:C1|x",
+		Args:   []string{"one,two", "three|four", "100%", "中文 text"},
+	}
+	data, err := s.Encode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(data, "|"); n != 4 {
+		t.Fatalf("%q has %d separators, want 4", data, n)
+	}
+	got, err := s.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("got %+v, want %+v", got, p)
+	}
+}
+
+func TestTampered(t *testing.T) {
+	s := NewSigner("secret")
+	data, err := s.Encode(Payload{Action: "rate", Ref: "group:C1", Args: []string{"up"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tampered := range map[string]string{
+		"action": strings.Replace(data, "|rate|", "|regen|", 1),
+		"ref":    strings.Replace(data, "group:C1", "group:C2", 1),
+		"args":   strings.Replace(data, "|up|", "|down|", 1),
+		"added":  strings.Replace(data, "|up|", "|up,grok|", 1),
+	} {
+		if tampered == data {
+			t.Fatalf("%s: replacement did not apply to %q", name, data)
+		}
+		if _, err := s.Decode(tampered); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: Decode(%q) = %v, want ErrBadSignature", name, tampered, err)
+		}
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	data, err := NewSigner("secret").Encode(Payload{Action: "regen", Ref: "user:U1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSigner("other").Decode(data); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Decode with another secret = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	s := NewSigner("secret")
+	data, err := s.Encode(Payload{Action: "regen", Ref: "user:U1", Args: []string{"x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signed re-signs a body, to reach the checks after the signature.
+	signed := func(body string) string { return body + "|" + s.sign(body) }
+
+	for name, tc := range map[string]struct {
+		data string
+		want error
+	}{
+		"empty":          {"", ErrMalformed},
+		"no separator":   {"regen", ErrMalformed},
+		"truncated":      {data[:len(data)-3], ErrBadSignature},
+		"no signature":   {data[:strings.LastIndex(data, "|")+1], ErrBadSignature},
+		"too few fields": {signed("v1|regen|user:U1"), ErrMalformed},
+		"extra field":    {signed("v1|regen|user:U1|x|y"), ErrMalformed},
+		"old version":    {signed("v0|regen|user:U1|x"), ErrMalformed},
+		"bad escape":     {signed("v1|regen|user:U1|%zz"), ErrMalformed},
+	} {
+		if _, err := s.Decode(tc.data); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Decode(%q) = %v, want %v", name, tc.data, err, tc.want)
+		}
+	}
+}
+
+func TestTooLong(t *testing.T) {
+	s := NewSigner("secret")
+	_, err := s.Encode(Payload{Action: "edit", Ref: "group:C1", Args: []string{strings.Repeat("x", MaxDataLength)}})
+	if !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode of a long arg = %v, want ErrTooLong", err)
+	}
+	// Escaping counts towards the limit.
+	_, err = s.Encode(Payload{Action: "edit", Ref: "group:C1", Args: []string{strings.Repeat("中", 40)}})
+	if !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode of a long escaped arg = %v, want ErrTooLong", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"linebot-grok/postback"
+	"log"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// signer signs and verifies button payloads. It is set up in main once the
+// secret is known.
+var signer *postback.Signer
+
+type postbackHandler func(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, p postback.Payload)
+
+var postbackHandlers = map[string]postbackHandler{
+	"regen":    regenPostback,
+	"model":    modelPostback,
+	"rate":     ratePostback,
+	"edit":     editPostback,
+	"reset":    resetPostback,
+	"forgetme": forgetMePostback,
+}
+
+// handlePostback verifies a postback payload and dispatches it to its action.
+func handlePostback(ctx context.Context, bot *messaging_api.MessagingApiAPI, e webhook.PostbackEvent) {
+	if e.Postback == nil {
+		return
+	}
+	p, err := signer.Decode(e.Postback.Data)
+	if err != nil {
+		log.Printf("Rejected postback: %v", err)
+		return
+	}
+	// A payload is only valid in the conversation it was issued for.
+	if ref, _ := conversationRef(e.Source); ref != p.Ref {
+		log.Printf("Rejected postback for %s from %s", p.Ref, ref)
+		return
+	}
+	handler, ok := postbackHandlers[p.Action]
+	if !ok {
+		log.Printf("Unknown postback action: %s", p.Action)
+		return
+	}
+	handler(withCommand(ctx, p.Action), bot, e.ReplyToken, p)
+}
+
+// postbackItem builds a quick reply button carrying a signed payload.
+func postbackItem(label string, displayText string, p postback.Payload) (messaging_api.QuickReplyItem, bool) {
+	data, err := signer.Encode(p)
+	if err != nil {
+		log.Printf("Error encoding postback %s: %v", p.Action, err)
+		return messaging_api.QuickReplyItem{}, false
+	}
+	return messaging_api.QuickReplyItem{
+		Type: "action",
+		Action: &messaging_api.PostbackAction{
+			Label:       truncateRunes(label, maxLabelRunes),
+			Data:        data,
+			DisplayText: displayText,
+		},
+	}, true
+}
+
+// regenPostback answers the question of the latest turn again, optionally
+// with another model, replacing the turn (args: [turn ID, model]).
+func regenPostback(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, p postback.Payload) {
+	ex, err := regenerateTurn(ctx, p.Ref, p.Arg(0), p.Arg(1))
+	if err != nil {
+		log.Printf("Error regenerating answer: %v", err)
+		replyText(bot, replyToken, turnError(p.Ref, err))
+		return
+	}
+	replyAnswer(ctx, bot, replyToken, p.Ref, ex)
+}
+
+// editPostback does nothing: the Edit button opens the keyboard with an
+// "AI edit" command for the user to change and send.
+func editPostback(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, p postback.Payload) {
+}
+
+// modelPostback switches the conversation's chat or image model (args: [model]).
+func modelPostback(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, p postback.Payload) {
+	replyText(bot, replyToken, selectModel(p.Ref, p.Arg(0)))
+}
+
+// ratePostback stores a thumbs up/down for an answer with its question
+// (args: ["up"|"down", turn ID]). Buttons without a turn ID rate the latest answer.
+func ratePostback(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, p postback.Payload) {
+	rating := 1
+	if p.Arg(0) == "down" {
+		rating = -1
+	}
+	if !rateTurn(ctx, p.Ref, p.Arg(1), rating) {
+		replyText(bot, replyToken, "I no longer remember that answer.")
+		return
+	}
+	replyText(bot, replyToken, "Thanks for the feedback!")
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"linebot-grok/postback"
+	"linebot-grok/store"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// confirmTTL is how long a reset or forget me button can be pressed.
+const confirmTTL = 10 * time.Minute
+
+// imageRefs are the refs an image is filed under: the conversation it was
+// made in and the user who asked for it, so either being purged deletes it.
+func imageRefs(ref string, userID string) []string {
+	if userID == "" || ref == userRef(userID) {
+		return []string{ref}
+	}
+	return []string{ref, userRef(userID)}
+}
+
+// replyConfirm asks before doing something that can't be undone. Not
+// pressing the button cancels.
+func replyConfirm(bot *messaging_api.MessagingApiAPI, replyToken string, text string, label string, p postback.Payload) {
+	item, ok := postbackItem(label, label, p)
+	if !ok {
+		replyText(bot, replyToken, "Sorry, I couldn't process your request.")
+		return
+	}
+	_, err := bot.ReplyMessage(&messaging_api.ReplyMessageRequest{
+		ReplyToken: replyToken,
+		Messages: []messaging_api.MessageInterface{&messaging_api.TextMessage{
+			Text:       text,
+			QuickReply: &messaging_api.QuickReply{Items: []messaging_api.QuickReplyItem{item}},
+		}},
+	})
+	if err != nil {
+		log.Printf("Error replying to message: %v", err)
+	}
+}
+
+// confirmArgs are the args of a confirmation button: who may press it and when it was issued.
+func confirmArgs(userID string) []string {
+	return []string{userID, strconv.FormatInt(time.Now().Unix(), 10)}
+}
+
+// confirmed reports whether the confirmation p was pressed in time by the
+// user it was issued to.
+func confirmed(ctx context.Context, p postback.Payload) bool {
+	issued, err := strconv.ParseInt(p.Arg(1), 10, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > confirmTTL {
+		return false
+	}
+	return p.Arg(0) == callerFrom(ctx).UserID
+}
+
+// resetCommand handles "AI reset" by asking for confirmation.
+func resetCommand(bot *messaging_api.MessagingApiAPI, replyToken string, ref string, userID string) {
+	replyConfirm(bot, replyToken,
+		"This clears what I remember of this chat: the recent messages, their summary and my latest answer. Settings are kept. Tap Reset to confirm.",
+		"Reset", postback.Payload{Action: "reset", Ref: ref, Args: confirmArgs(userID)})
+}
+
+// resetPostback clears the conversation's context (args: [user ID, issued at]).
+func resetPostback(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, p postback.Payload) {
+	if !confirmed(ctx, p) {
+		replyText(bot, replyToken, fmt.Sprintf("This button has expired or isn't yours. Send %q again.", command("reset")))
+		return
+	}
+	c.Delete(p.Ref)
+	st.ClearHistory(p.Ref)
+	st.ClearSummary(p.Ref)
+	replyText(bot, replyToken, "Done, I've forgotten this conversation. Settings are unchanged.")
+}
+
+// forgetMeCommand handles "AI forget me" by asking for confirmation.
+func forgetMeCommand(bot *messaging_api.MessagingApiAPI, replyToken string, ref string, userID string) {
+	if userID == "" {
+		replyText(bot, replyToken, "I can't tell who you are in this chat.")
+		return
+	}
+	replyConfirm(bot, replyToken,
+		"This deletes everything I store about you: our one-to-one chat and its settings, your location, images you asked for, your usage records, your ratings of answers, and your questions and messages in group chats. Tap Forget me to confirm.",
+		"Forget me", postback.Payload{Action: "forgetme", Ref: ref, Args: confirmArgs(userID)})
+}
+
+// forgetMePostback deletes all data of the user who pressed it (args: [user ID, issued at]).
+func forgetMePostback(ctx context.Context, bot *messaging_api.MessagingApiAPI, replyToken string, p postback.Payload) {
+	if !confirmed(ctx, p) {
+		replyText(bot, replyToken, fmt.Sprintf("This button has expired or isn't yours. Send %q again.", command("forget me")))
+		return
+	}
+	userID := p.Arg(0)
+	c.Delete(userRef(userID))
+	st.Purge(userRef(userID))
+	st.ForgetUser(userID)
+	// An export of the chat they asked in may hold their messages.
+	st.DeleteExports(p.Ref)
+	for ref, item := range c.Items() {
+		turns, ok := item.Object.([]store.Exchange)
+		if !ok || !slices.ContainsFunc(turns, func(t store.Exchange) bool { return t.UserID == userID }) {
+			continue
+		}
+		c.SetDefault(ref, slices.DeleteFunc(slices.Clone(turns), func(t store.Exchange) bool { return t.UserID == userID }))
+		// The summary and exports may be built on their turns too.
+		st.ClearSummary(ref)
+		st.DeleteExports(ref)
+	}
+	st.DeleteUsage("line:" + userID)
+	if err := feedbackLog.Forget(userID); err != nil {
+		log.Printf("Error deleting feedback of %s: %v", userID, err)
+	}
+	nameCache.Delete(userID)
+	log.Printf("Deleted stored data of user %s", userID)
+	replyText(bot, replyToken, "Everything I stored about you has been deleted.")
+}
+
+// exportCommand handles "AI export": the conversation as a text file behind a download link.
+func exportCommand(ref string, userID string) string {
+	url, err := exportURL(st.SaveExport(ref, buildExport(ref, userID)))
+	if err != nil {
+		return friendlyError(ref, err)
+	}
+	return fmt.Sprintf("Download this chat's history within %s:\n%s", shortDuration(cfg.Cache.ImageTTL), url)
+}
+
+// buildExport writes out what is stored for the conversation.
+func buildExport(ref string, userID string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Conversation %s, exported %s\n", ref, time.Now().In(usageZone).Format("2006-01-02 15:04 MST")))
+
+	settings := st.Settings(ref)
+	for _, s := range []struct{ name, value string }{
+		{"Chat model", settings.ChatModel},
+		{"Image model", settings.ImageModel},
+		{"Persona", settings.Persona},
+		{"Language", settings.Lang},
+	} {
+		if s.value != "" {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", s.name, s.value))
+		}
+	}
+	// Exports from groups are shared with the group, so only show the
+	// location in the user's own chat.
+	if loc := userLocation(userID); loc != nil && ref == userRef(userID) {
+		sb.WriteString(fmt.Sprintf("Your location: %s\n", loc))
+	}
+
+	if sum, ok := conversationSummary(ref); ok {
+		sb.WriteString("\n== Summary of earlier messages ==\n" + sum.Text + "\n")
+	}
+	if turns := contextTurns(ref); len(turns) > 0 {
+		sb.WriteString("\n== Recent messages ==\n")
+		for _, t := range turns {
+			sb.WriteString(fmt.Sprintf("[%s %s] Q: %s\n", t.ID, t.Model, t.Question))
+			sb.WriteString("A: " + t.Answer + "\n")
+		}
+	}
+	if ex, ok := st.LastExchange(ref); ok {
+		sb.WriteString(fmt.Sprintf("\n== Latest answer (%s, %s) ==\n", ex.Model, ex.Time.In(usageZone).Format("2006-01-02 15:04")))
+		sb.WriteString("Q: " + ex.Question + "\n")
+		sb.WriteString("A: " + ex.Answer + "\n")
+	}
+	if lines := st.RecentGroupLog(ref, 0, time.Time{}); len(lines) > 0 {
+		sb.WriteString("\n== Group log ==\n")
+		for _, l := range lines {
+			sb.WriteString(fmt.Sprintf("[%s] %s: %s\n", l.Time.In(usageZone).Format("2006-01-02 15:04"), l.Name, l.Text))
+		}
+	}
+	return sb.String()
+}
+
+// exportRoute serves a conversation export as a download.
+func exportRoute(w http.ResponseWriter, r *http.Request) {
+	text, found := st.Export(r.PathValue("id"))
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="chat-export.txt"`)
+	w.Write([]byte(text))
+}
@@ -0,0 +1,50 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	mu            sync.RWMutex
+	defaultPrompt string
+)
+
+// LoadDefault reads the server-wide system prompt from path.
+// A missing file is not an error; the bot then runs without a default prompt.
+func LoadDefault(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read system prompt %s: %w", path, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	defaultPrompt = strings.TrimSpace(string(data))
+	return nil
+}
+
+func Default() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return defaultPrompt
+}
+
+// Build combines the default prompt with a chat's persona and language preference.
+func Build(persona string, lang string) string {
+	parts := []string{}
+	if d := Default(); d != "" {
+		parts = append(parts, d)
+	}
+	if persona != "" {
+		parts = append(parts, persona)
+	}
+	if lang != "" {
+		parts = append(parts, fmt.Sprintf("Always reply in %s.", lang))
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerPolicy controls when a provider's circuit breaker opens.
+type BreakerPolicy struct {
+	// Threshold is the number of consecutive failed calls that opens the circuit.
+	Threshold int `yaml:"threshold"`
+	// Cooldown is how long the circuit stays open before one call is let through again.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+var DefaultBreaker = BreakerPolicy{
+	Threshold: 5,
+	Cooldown:  30 * time.Second,
+}
+
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	}
+	return "closed"
+}
+
+// Breaker stops calling a provider that keeps failing, so callers can move
+// on to a fallback right away instead of waiting for timeouts.
+type Breaker struct {
+	name   string
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// probing is set while the single half-open call is in flight.
+	probing bool
+	// now is the clock, replaced in tests.
+	now func() time.Time
+}
+
+func NewBreaker(name string, policy BreakerPolicy) *Breaker {
+	if policy.Threshold <= 0 {
+		policy.Threshold = DefaultBreaker.Threshold
+	}
+	if policy.Cooldown <= 0 {
+		policy.Cooldown = DefaultBreaker.Cooldown
+	}
+	return &Breaker{name: name, policy: policy, now: time.Now}
+}
+
+// Allow returns nil if a call may go through, and an unavailable error with
+// the remaining cooldown as RetryAfter otherwise.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		wait := b.policy.Cooldown - b.now().Sub(b.openedAt)
+		if wait > 0 {
+			return &Error{Provider: b.name, Kind: KindUnavailable, RetryAfter: wait, Message: "circuit open"}
+		}
+		b.setState(StateHalfOpen)
+		b.probing = true
+	case StateHalfOpen:
+		if b.probing {
+			return &Error{Provider: b.name, Kind: KindUnavailable, Message: "circuit half open"}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Success records a call the provider answered.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.setState(StateClosed)
+}
+
+// Failure records a call that failed because of the provider.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == StateHalfOpen || b.failures >= b.policy.Threshold {
+		b.openedAt = b.now()
+		b.setState(StateOpen)
+	}
+}
+
+// Cancel records a call that says nothing about the provider: one abandoned
+// by the caller, or answered with an error that isn't an outage. A half-open
+// circuit stays half open for the next call to probe.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// setState logs transitions. Caller must hold b.mu.
+func (b *Breaker) setState(s State) {
+	if b.state != s {
+		log.Printf("%s: circuit %s -> %s", b.name, b.state, s)
+		b.state = s
+	}
+}
+
+// BreakerStatus is a snapshot of a breaker.
+type BreakerStatus struct {
+	Provider string    `json:"provider"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt,omitzero"`
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{Provider: b.name, State: b.state.String(), Failures: b.failures}
+	if b.state != StateClosed {
+		s.OpenedAt = b.openedAt
+	}
+	return s
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*Breaker{}
+)
+
+// register makes b the breaker reported for its provider.
+func register(b *Breaker) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	breakers[b.name] = b
+}
+
+// Breakers returns the state of every provider's breaker, sorted by provider.
+func Breakers() []BreakerStatus {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	list := []BreakerStatus{}
+	for _, b := range breakers {
+		list = append(list, b.Status())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Provider < list[j].Provider })
+	return list
+}
+
+// Outage reports whether err means the provider can't answer right now, as
+// opposed to it answering with something we can't use. Outages count against
+// the breaker and are worth trying another provider for.
+func Outage(err error) bool {
+	switch KindOf(err) {
+	case KindAuth, KindRateLimited, KindQuota, KindTimeout, KindUnavailable:
+		return true
+	}
+	return false
+}
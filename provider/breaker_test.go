@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock the test moves by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestBreaker(t *testing.T) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)}
+	b := NewBreaker("test-"+t.Name(), BreakerPolicy{Threshold: 3, Cooldown: 30 * time.Second})
+	b.now = clock.now
+	return b, clock
+}
+
+func checkState(t *testing.T, b *Breaker, want State) {
+	t.Helper()
+	if got := b.Status().State; got != want.String() {
+		t.Errorf("state = %s, want %s", got, want)
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(t)
+	for range 2 {
+		if err := b.Allow(); err != nil {
+			t.Fatal(err)
+		}
+		b.Failure()
+	}
+	checkState(t, b, StateClosed)
+
+	// A success in between starts the count again.
+	b.Allow()
+	b.Success()
+	for range 2 {
+		b.Allow()
+		b.Failure()
+	}
+	checkState(t, b, StateClosed)
+
+	b.Allow()
+	b.Failure()
+	checkState(t, b, StateOpen)
+	if err := b.Allow(); KindOf(err) != KindUnavailable {
+		t.Errorf("open circuit allowed a call: %v", err)
+	}
+}
+
+func TestBreakerCooldown(t *testing.T) {
+	b, clock := newTestBreaker(t)
+	for range 3 {
+		b.Allow()
+		b.Failure()
+	}
+
+	clock.advance(20 * time.Second)
+	err := b.Allow()
+	e, ok := err.(*Error)
+	if !ok || e.RetryAfter != 10*time.Second {
+		t.Fatalf("Allow = %v, want 10s left of the cooldown", err)
+	}
+
+	clock.advance(10 * time.Second)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow after the cooldown = %v", err)
+	}
+	checkState(t, b, StateHalfOpen)
+	if err := b.Allow(); err == nil {
+		t.Error("half-open circuit let a second call through")
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		outcome func(b *Breaker)
+		want    State
+	}{
+		{"success closes", (*Breaker).Success, StateClosed},
+		{"failure reopens", (*Breaker).Failure, StateOpen},
+		{"cancel stays half open", (*Breaker).Cancel, StateHalfOpen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, clock := newTestBreaker(t)
+			for range 3 {
+				b.Allow()
+				b.Failure()
+			}
+			clock.advance(30 * time.Second)
+			if err := b.Allow(); err != nil {
+				t.Fatal(err)
+			}
+			tc.outcome(b)
+			checkState(t, b, tc.want)
+		})
+	}
+}
+
+func TestBreakerReopenRestartsCooldown(t *testing.T) {
+	b, clock := newTestBreaker(t)
+	for range 3 {
+		b.Allow()
+		b.Failure()
+	}
+	clock.advance(time.Minute)
+	b.Allow()
+	b.Failure()
+
+	clock.advance(29 * time.Second)
+	if err := b.Allow(); err == nil {
+		t.Error("reopened circuit allowed a call before its cooldown")
+	}
+	clock.advance(time.Second)
+	if err := b.Allow(); err != nil {
+		t.Errorf("Allow after the second cooldown = %v", err)
+	}
+}
+
+func TestRunOnlyClosesOnSuccess(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want State
+	}{
+		{"success", nil, StateClosed},
+		{"outage", New("test", KindUnavailable, "down"), StateOpen},
+		{"blocked", New("test", KindSafety, "blocked"), StateHalfOpen},
+		{"bad response", New("test", KindBadResponse, "bad request"), StateHalfOpen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestClient(t, time.Second, RetryPolicy{MaxAttempts: 1})
+			b, clock := newTestBreaker(t)
+			c.Breaker = b
+			for range 3 {
+				b.Allow()
+				b.Failure()
+			}
+			clock.advance(30 * time.Second)
+
+			c.Run(context.Background(), func(ctx context.Context) error { return tc.err })
+			checkState(t, b, tc.want)
+		})
+	}
+}
+
+func TestRunNonOutageKeepsFailureCount(t *testing.T) {
+	c := newTestClient(t, time.Second, RetryPolicy{MaxAttempts: 1})
+	b, _ := newTestBreaker(t)
+	c.Breaker = b
+	outage := func(ctx context.Context) error { return New("test", KindUnavailable, "down") }
+	blocked := func(ctx context.Context) error { return New("test", KindSafety, "blocked") }
+
+	c.Run(context.Background(), outage)
+	c.Run(context.Background(), outage)
+	c.Run(context.Background(), blocked)
+	if n := b.Status().Failures; n != 2 {
+		t.Errorf("failures = %d after a blocked answer, want 2", n)
+	}
+	c.Run(context.Background(), outage)
+	checkState(t, b, StateOpen)
+}
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how failed provider calls are retried.
+type RetryPolicy struct {
+	// MaxAttempts includes the first try; 1 disables retries.
+	MaxAttempts int           `yaml:"maxAttempts"`
+	BaseDelay   time.Duration `yaml:"baseDelay"`
+	MaxDelay    time.Duration `yaml:"maxDelay"`
+}
+
+// DefaultRetry is used for providers without their own policy.
+var DefaultRetry = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    8 * time.Second,
+}
+
+// Client is the shared HTTP client of one provider.
+type Client struct {
+	Name    string
+	HTTP    *http.Client
+	Retry   RetryPolicy
+	Breaker *Breaker
+}
+
+func NewClient(name string, timeout time.Duration, retry RetryPolicy, breaker BreakerPolicy) *Client {
+	if retry.MaxAttempts <= 0 {
+		retry = DefaultRetry
+	}
+	c := &Client{
+		Name:    name,
+		HTTP:    &http.Client{Timeout: timeout},
+		Retry:   retry,
+		Breaker: NewBreaker(name, breaker),
+	}
+	register(c.Breaker)
+	return c
+}
+
+// Do sends the request built by newReq and returns the body of a 200 response.
+// Rate limits, server errors and timeouts are retried with jittered
+// exponential backoff; everything else is returned right away.
+func (c *Client) Do(ctx context.Context, newReq func(ctx context.Context) (*http.Request, error)) ([]byte, error) {
+	var body []byte
+	err := c.Run(ctx, func(ctx context.Context) error {
+		req, err := newReq(ctx)
+		if err != nil {
+			return FromTransport(c.Name, err)
+		}
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return FromTransport(c.Name, err)
+		}
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return FromTransport(c.Name, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return FromStatus(c.Name, resp.StatusCode, resp.Header, b)
+		}
+		body = b
+		return nil
+	})
+	return body, err
+}
+
+// Run calls fn until it succeeds, fails with an error that isn't worth
+// retrying, or the retry policy is exhausted. The outcome is recorded by the
+// breaker, and nothing is tried while it is open.
+func (c *Client) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := c.Breaker.Allow(); err != nil {
+		return err
+	}
+	err := c.retry(ctx, fn)
+	switch {
+	case err == nil:
+		c.Breaker.Success()
+	case ctx.Err() != nil || !Outage(err):
+		c.Breaker.Cancel()
+	default:
+		c.Breaker.Failure()
+	}
+	return err
+}
+
+func (c *Client) retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < c.Retry.MaxAttempts; attempt++ {
+		err = fn(ctx)
+		if err == nil || !Retryable(err) || ctx.Err() != nil || attempt == c.Retry.MaxAttempts-1 {
+			break
+		}
+
+		delay := c.backoff(attempt)
+		if pe, ok := err.(*Error); ok && pe.RetryAfter > 0 {
+			// Don't wait longer than we would for our own backoff.
+			if pe.RetryAfter > c.Retry.MaxDelay {
+				break
+			}
+			delay = max(delay, pe.RetryAfter)
+		}
+		log.Printf("%s: attempt %d failed (%v), retrying in %s", c.Name, attempt+1, err, delay)
+		if sleepErr := sleepCtx(ctx, delay); sleepErr != nil {
+			return FromTransport(c.Name, sleepErr)
+		}
+	}
+	return err
+}
+
+// backoff returns a random delay in [0, min(MaxDelay, BaseDelay*2^attempt)].
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.Retry.BaseDelay << attempt
+	if d <= 0 || d > c.Retry.MaxDelay {
+		d = c.Retry.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
+
+// Retryable reports whether trying the same call again may succeed.
+func Retryable(err error) bool {
+	switch KindOf(err) {
+	case KindRateLimited, KindTimeout, KindUnavailable:
+		return true
+	}
+	return false
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,190 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// standIn serves the responses in order, repeating the last one, and
+// counts the requests it gets.
+type standIn struct {
+	*httptest.Server
+	calls atomic.Int32
+	// onRequest is called for each request, before it is answered.
+	onRequest func()
+}
+
+type response struct {
+	status     int
+	retryAfter string
+	delay      time.Duration
+}
+
+func newStandIn(t *testing.T, responses ...response) *standIn {
+	t.Helper()
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(s.calls.Add(1))
+		if s.onRequest != nil {
+			s.onRequest()
+		}
+		resp := responses[min(n, len(responses))-1]
+		if resp.delay > 0 {
+			select {
+			case <-time.After(resp.delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if resp.retryAfter != "" {
+			w.Header().Set("Retry-After", resp.retryAfter)
+		}
+		w.WriteHeader(resp.status)
+		w.Write([]byte(`{"status":` + strconv.Itoa(resp.status) + `}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestClient(t *testing.T, timeout time.Duration, retry RetryPolicy) *Client {
+	return NewClient("test-"+t.Name(), timeout, retry, BreakerPolicy{Threshold: 100, Cooldown: time.Minute})
+}
+
+func (s *standIn) get(ctx context.Context, c *Client) ([]byte, error) {
+	return c.Do(ctx, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	})
+}
+
+var quickRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetriesRateLimitsAndServerErrors(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		s := newStandIn(t, response{status: status}, response{status: status}, response{status: http.StatusOK})
+		body, err := s.get(context.Background(), newTestClient(t, time.Second, quickRetry))
+		if err != nil {
+			t.Fatalf("status %d: %v", status, err)
+		}
+		if string(body) != `{"status":200}` {
+			t.Errorf("status %d: body %q", status, body)
+		}
+		if n := s.calls.Load(); n != 3 {
+			t.Errorf("status %d: %d calls, want 3", status, n)
+		}
+	}
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	s := newStandIn(t, response{status: http.StatusServiceUnavailable})
+	_, err := s.get(context.Background(), newTestClient(t, time.Second, quickRetry))
+	if KindOf(err) != KindUnavailable {
+		t.Errorf("err = %v, want unavailable", err)
+	}
+	if n := s.calls.Load(); n != 3 {
+		t.Errorf("%d calls, want 3", n)
+	}
+}
+
+func TestHonorsRetryAfter(t *testing.T) {
+	for name, retryAfter := range map[string]func() string{
+		"seconds":   func() string { return "1" },
+		"HTTP date": func() string { return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			value := retryAfter()
+			want := ParseRetryAfter(value)
+			if want <= 0 {
+				t.Fatalf("Retry-After %q did not parse", value)
+			}
+			s := newStandIn(t, response{status: http.StatusTooManyRequests, retryAfter: value}, response{status: http.StatusOK})
+			start := time.Now()
+			if _, err := s.get(context.Background(), newTestClient(t, time.Second, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 3 * time.Second})); err != nil {
+				t.Fatal(err)
+			}
+			// The HTTP date only has whole seconds, so allow for the rounding.
+			if waited := time.Since(start); waited < want-time.Second/2 {
+				t.Errorf("waited %s, want about %s", waited, want)
+			}
+		})
+	}
+}
+
+func TestGivesUpWhenRetryAfterExceedsMaxDelay(t *testing.T) {
+	s := newStandIn(t, response{status: http.StatusTooManyRequests, retryAfter: "60"}, response{status: http.StatusOK})
+	start := time.Now()
+	_, err := s.get(context.Background(), newTestClient(t, time.Second, quickRetry))
+	var pe *Error
+	if !errors.As(err, &pe) || pe.Kind != KindRateLimited || pe.RetryAfter != time.Minute {
+		t.Fatalf("err = %v, want rate limited with a minute to wait", err)
+	}
+	if n := s.calls.Load(); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %s before giving up", waited)
+	}
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+	for status, kind := range map[int]Kind{
+		http.StatusBadRequest:   KindBadResponse,
+		http.StatusUnauthorized: KindAuth,
+	} {
+		s := newStandIn(t, response{status: status}, response{status: http.StatusOK})
+		_, err := s.get(context.Background(), newTestClient(t, time.Second, quickRetry))
+		if err == nil || KindOf(err) != kind {
+			t.Errorf("status %d: err = %v, want kind %s", status, err, kind)
+		}
+		if n := s.calls.Load(); n != 1 {
+			t.Errorf("status %d: %d calls, want 1", status, n)
+		}
+	}
+}
+
+func TestStopsWhenContextCancelled(t *testing.T) {
+	s := newStandIn(t, response{status: http.StatusServiceUnavailable})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.onRequest = cancel
+
+	start := time.Now()
+	_, err := s.get(ctx, newTestClient(t, time.Second, RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Second, MaxDelay: 10 * time.Second}))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("took %s to stop", waited)
+	}
+	if n := s.calls.Load(); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+}
+
+func TestPerAttemptTimeout(t *testing.T) {
+	t.Run("retried", func(t *testing.T) {
+		s := newStandIn(t, response{status: http.StatusOK, delay: time.Second}, response{status: http.StatusOK})
+		if _, err := s.get(context.Background(), newTestClient(t, 50*time.Millisecond, quickRetry)); err != nil {
+			t.Fatal(err)
+		}
+		if n := s.calls.Load(); n != 2 {
+			t.Errorf("%d calls, want 2", n)
+		}
+	})
+	t.Run("every attempt", func(t *testing.T) {
+		s := newStandIn(t, response{status: http.StatusOK, delay: time.Second})
+		start := time.Now()
+		_, err := s.get(context.Background(), newTestClient(t, 50*time.Millisecond, quickRetry))
+		if KindOf(err) != KindTimeout {
+			t.Errorf("err = %v, want timeout", err)
+		}
+		if waited := time.Since(start); waited > 900*time.Millisecond {
+			t.Errorf("took %s, want each attempt cut at the timeout", waited)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind classifies why a provider call failed.
+type Kind int
+
+const (
+	KindUnknown Kind = iota
+	// KindAuth means the API key is missing, invalid or not allowed to use the model.
+	KindAuth
+	// KindRateLimited means too many requests; retrying later should work.
+	KindRateLimited
+	// KindQuota means the account ran out of quota or credits.
+	KindQuota
+	// KindSafety means the prompt or the answer was blocked by safety filters.
+	KindSafety
+	// KindTimeout means the provider didn't answer in time.
+	KindTimeout
+	// KindUnavailable means the provider returned a server error.
+	KindUnavailable
+	// KindBadResponse means the provider answered with something we couldn't use.
+	KindBadResponse
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindAuth:
+		return "auth"
+	case KindRateLimited:
+		return "rate_limited"
+	case KindQuota:
+		return "quota"
+	case KindSafety:
+		return "safety_blocked"
+	case KindTimeout:
+		return "timeout"
+	case KindUnavailable:
+		return "unavailable"
+	case KindBadResponse:
+		return "bad_response"
+	}
+	return "unknown"
+}
+
+// Error is returned by every provider call.
+type Error struct {
+	Provider   string
+	Kind       Kind
+	StatusCode int
+	// RetryAfter is the delay the provider asked for, if any.
+	RetryAfter time.Duration
+	Message    string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Provider, e.Kind)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is lets errors.Is match on the kind sentinels below.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Provider == "" && t.Kind == e.Kind
+}
+
+// Sentinels for errors.Is.
+var (
+	ErrAuth        = &Error{Kind: KindAuth}
+	ErrRateLimited = &Error{Kind: KindRateLimited}
+	ErrQuota       = &Error{Kind: KindQuota}
+	ErrSafety      = &Error{Kind: KindSafety}
+	ErrTimeout     = &Error{Kind: KindTimeout}
+	ErrUnavailable = &Error{Kind: KindUnavailable}
+	ErrBadResponse = &Error{Kind: KindBadResponse}
+)
+
+// New builds an error of the given kind.
+func New(provider string, kind Kind, format string, args ...any) *Error {
+	return &Error{Provider: provider, Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// KindOf returns the kind of err, or KindUnknown if it isn't a provider error.
+func KindOf(err error) Kind {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe.Kind
+	}
+	return KindUnknown
+}
+
+// FromStatus classifies a non-2xx HTTP response.
+func FromStatus(provider string, status int, header http.Header, body []byte) *Error {
+	e := &Error{
+		Provider:   provider,
+		StatusCode: status,
+		Message:    strings.TrimSpace(string(body)),
+	}
+	if header != nil {
+		e.RetryAfter = ParseRetryAfter(header.Get("Retry-After"))
+	}
+	lower := strings.ToLower(e.Message)
+	quota := strings.Contains(lower, "quota") || strings.Contains(lower, "credit") || strings.Contains(lower, "billing")
+	switch {
+	case status == http.StatusUnauthorized:
+		e.Kind = KindAuth
+	case status == http.StatusPaymentRequired:
+		e.Kind = KindQuota
+	case status == http.StatusForbidden && quota:
+		e.Kind = KindQuota
+	case status == http.StatusForbidden:
+		e.Kind = KindAuth
+	case status == http.StatusBadRequest && (strings.Contains(lower, "api key") || strings.Contains(lower, "api_key")):
+		// Gemini reports invalid keys as 400 API_KEY_INVALID.
+		e.Kind = KindAuth
+	case status == http.StatusTooManyRequests && quota && e.RetryAfter == 0:
+		e.Kind = KindQuota
+	case status == http.StatusTooManyRequests:
+		e.Kind = KindRateLimited
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		e.Kind = KindTimeout
+	case status >= 500:
+		e.Kind = KindUnavailable
+	default:
+		e.Kind = KindBadResponse
+	}
+	return e
+}
+
+// FromTransport classifies an error from sending the request or reading the response.
+func FromTransport(provider string, err error) *Error {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe
+	}
+	kind := KindUnavailable
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		kind = KindTimeout
+	}
+	return &Error{Provider: provider, Kind: kind, Err: err}
+}
+
+// ParseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func ParseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// HTTPStatus maps err to the status code our own HTTP routes should return.
+func HTTPStatus(err error) int {
+	switch KindOf(err) {
+	case KindAuth:
+		// Our key is the problem, not the caller's request.
+		return http.StatusBadGateway
+	case KindRateLimited, KindQuota:
+		return http.StatusTooManyRequests
+	case KindSafety:
+		return http.StatusUnprocessableEntity
+	case KindTimeout:
+		return http.StatusGatewayTimeout
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
+	case KindBadResponse:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// WriteHTTPError responds to a caller of our HTTP routes with the status
+// matching err, passing Retry-After through.
+func WriteHTTPError(w http.ResponseWriter, err error) {
+	var pe *Error
+	if errors.As(err, &pe) && pe.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(pe.RetryAfter.Round(time.Second).Seconds())))
+	}
+	http.Error(w, "Failed to generate response: "+KindOf(err).String(), HTTPStatus(err))
+}
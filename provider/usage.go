@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage is what one provider call consumed.
+type Usage struct {
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Images           int
+}
+
+var (
+	usageMu   sync.RWMutex
+	usageHook func(ctx context.Context, u Usage)
+)
+
+// OnUsage sets the function every provider call reports its usage to.
+func OnUsage(fn func(ctx context.Context, u Usage)) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	usageHook = fn
+}
+
+// RecordUsage reports a successful call. ctx is the context of the call, so
+// the hook can tell who it was made for.
+func RecordUsage(ctx context.Context, u Usage) {
+	usageMu.RLock()
+	fn := usageHook
+	usageMu.RUnlock()
+	if fn != nil {
+		fn(ctx, u)
+	}
+}
+
+// Price is what a model costs, in US dollars.
+type Price struct {
+	InputPerMillion  float64 `yaml:"inputPerMillion" json:"inputPerMillion"`
+	OutputPerMillion float64 `yaml:"outputPerMillion" json:"outputPerMillion"`
+	PerImage         float64 `yaml:"perImage" json:"perImage"`
+}
+
+// Pricing maps provider model IDs to their price.
+type Pricing map[string]Price
+
+// DefaultPricing are the list prices of the built-in models.
+var DefaultPricing = Pricing{
+	"gemini-2.0-flash":                      {InputPerMillion: 0.10, OutputPerMillion: 0.40},
+	"gemini-2.0-flash-exp-image-generation": {InputPerMillion: 0.10, OutputPerMillion: 0.40, PerImage: 0.039},
+	"grok-3-beta":                           {InputPerMillion: 3, OutputPerMillion: 15},
+	"grok-2-image-1212":                     {PerImage: 0.07},
+}
+
+// Cost returns the price of u. Models missing from p cost nothing.
+func (p Pricing) Cost(u Usage) float64 {
+	price := p[u.Model]
+	return float64(u.PromptTokens)*price.InputPerMillion/1e6 +
+		float64(u.CompletionTokens)*price.OutputPerMillion/1e6 +
+		float64(u.Images)*price.PerImage
+}
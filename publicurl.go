@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"linebot-grok/provider"
+	"log"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// publicBase is the scheme and host the bot's own URLs (generated images)
+// are reachable under, e.g. "https://bot.example.com". Empty while unknown.
+var publicBase atomic.Value
+
+// errNoPublicURL is returned when an image or export can't be linked
+// because the public base URL isn't known yet.
+var errNoPublicURL = provider.New("line", provider.KindUnavailable, "public base URL is not known yet")
+
+func publicBaseURL() string {
+	base, _ := publicBase.Load().(string)
+	return base
+}
+
+func setPublicBaseURL(base string) {
+	publicBase.Store(strings.TrimRight(base, "/"))
+}
+
+// imageURL returns the public URL of a stored image.
+func imageURL(key string) (string, error) {
+	return publicURL("/img/" + key)
+}
+
+// exportURL returns the download URL of a conversation export.
+func exportURL(key string) (string, error) {
+	return publicURL("/export/" + key)
+}
+
+func publicURL(path string) (string, error) {
+	base := publicBaseURL()
+	if base == "" {
+		return "", errNoPublicURL
+	}
+	return base + path, nil
+}
+
+// lookupPublicBaseURL derives the base URL from the webhook endpoint set in
+// the LINE Developers console.
+func lookupPublicBaseURL(bot *messaging_api.MessagingApiAPI) (string, error) {
+	resp, err := bot.GetWebhookEndpoint()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(resp.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("unusable webhook endpoint %q", resp.Endpoint)
+	}
+	// only get host and scheme
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// refreshPublicBaseURL looks the base URL up again every interval, so a
+// changed webhook endpoint is picked up without a restart. Until a lookup
+// has succeeded it retries every minute.
+func refreshPublicBaseURL(ctx context.Context, bot *messaging_api.MessagingApiAPI, every time.Duration) {
+	for {
+		wait := every
+		if publicBaseURL() == "" {
+			wait = min(every, time.Minute)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		base, err := lookupPublicBaseURL(bot)
+		if err != nil {
+			log.Printf("Failed to look up the webhook endpoint: %v", err)
+			continue
+		}
+		if base != publicBaseURL() {
+			log.Printf("Public base URL is now %s", base)
+			setPublicBaseURL(base)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package ratelimit enforces per-subject token-bucket rate limits and daily
+// quotas, separately for each kind of request.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kind is what a request costs: a chat answer, a search-grounded answer or an image.
+type Kind string
+
+const (
+	KindChat   Kind = "chat"
+	KindSearch Kind = "search"
+	KindImage  Kind = "image"
+)
+
+// Policy limits one kind of request. Zero values mean no limit.
+type Policy struct {
+	// PerMinute is the sustained rate.
+	PerMinute float64 `yaml:"perMinute"`
+	// Burst is how many requests may be made at once; defaults to PerMinute rounded up.
+	Burst int `yaml:"burst"`
+	// Daily is the number of requests per calendar day.
+	Daily int `yaml:"daily"`
+}
+
+// Error is returned when a request is over its limit.
+type Error struct {
+	Kind Kind
+	// Daily is set when the daily quota is used up, as opposed to the rate.
+	Daily bool
+	Limit int
+	// RetryAfter is how long until the request would be allowed.
+	RetryAfter time.Duration
+	// ResetAt is when the daily quota resets.
+	ResetAt time.Time
+}
+
+func (e *Error) Error() string {
+	if e.Daily {
+		return fmt.Sprintf("daily %s quota of %d used up, resets at %s", e.Kind, e.Limit, e.ResetAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s rate limit exceeded, retry in %s", e.Kind, e.RetryAfter.Round(time.Second))
+}
+
+type key struct {
+	kind    Kind
+	subject string
+}
+
+type bucket struct {
+	policy Policy
+	tokens float64
+	last   time.Time
+	day    string
+	used   int
+}
+
+// Limiter tracks usage per subject, such as "line:Uxxx" or "ip:203.0.113.9".
+type Limiter struct {
+	policies map[Kind]Policy
+	// loc is the time zone days start in.
+	loc *time.Location
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[key]*bucket
+	lastPrune time.Time
+}
+
+func New(policies map[Kind]Policy, loc *time.Location) *Limiter {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Limiter{
+		policies: policies,
+		loc:      loc,
+		now:      time.Now,
+		buckets:  map[key]*bucket{},
+	}
+}
+
+// Policy returns the limiter's policy for kind.
+func (l *Limiter) Policy(kind Kind) Policy {
+	return l.policies[kind]
+}
+
+// Allow takes one request of kind for subject, or returns an *Error if the
+// subject is over its rate or daily quota.
+func (l *Limiter) Allow(kind Kind, subject string) error {
+	return l.AllowPolicy(kind, subject, l.policies[kind])
+}
+
+// AllowPolicy is Allow with a policy of the subject's own instead of the
+// limiter's, such as the quota of an API key.
+func (l *Limiter) AllowPolicy(kind Kind, subject string, p Policy) error {
+	if p.PerMinute <= 0 && p.Daily <= 0 {
+		return nil
+	}
+	now := l.now()
+	day := now.In(l.loc).Format(time.DateOnly)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pruneLocked(now, day)
+
+	b, ok := l.buckets[key{kind, subject}]
+	if !ok {
+		b = &bucket{policy: p, tokens: float64(burst(p)), last: now, day: day}
+		l.buckets[key{kind, subject}] = b
+	}
+	b.policy = p
+	if b.day != day {
+		b.day, b.used = day, 0
+	}
+	if p.Daily > 0 && b.used >= p.Daily {
+		reset := l.nextDay(now)
+		return &Error{Kind: kind, Daily: true, Limit: p.Daily, RetryAfter: reset.Sub(now), ResetAt: reset}
+	}
+	if p.PerMinute > 0 {
+		perSecond := p.PerMinute / 60
+		b.tokens = math.Min(float64(burst(p)), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+		b.last = now
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+			return &Error{Kind: kind, Limit: burst(p), RetryAfter: wait}
+		}
+		b.tokens--
+	}
+	b.used++
+	return nil
+}
+
+// Usage returns how many requests of kind subject made today and its daily quota.
+func (l *Limiter) Usage(kind Kind, subject string) (used int, daily int) {
+	day := l.now().In(l.loc).Format(time.DateOnly)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key{kind, subject}]
+	if !ok {
+		return 0, l.policies[kind].Daily
+	}
+	if b.day == day {
+		used = b.used
+	}
+	return used, b.policy.Daily
+}
+
+// Today is the day daily quotas are counted for, in the limiter's time zone.
+func (l *Limiter) Today() string {
+	return l.now().In(l.loc).Format(time.DateOnly)
+}
+
+// Restore sets how many requests of kind subject made today, e.g. from a
+// count kept across restarts, so its daily quota still holds.
+func (l *Limiter) Restore(kind Kind, subject string, p Policy, used int) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key{kind, subject}]
+	if !ok {
+		b = &bucket{policy: p, tokens: float64(burst(p)), last: now}
+		l.buckets[key{kind, subject}] = b
+	}
+	b.day, b.used = now.In(l.loc).Format(time.DateOnly), used
+}
+
+// Count is how many requests of a kind a subject made today.
+type Count struct {
+	Kind    Kind
+	Subject string
+	Used    int
+}
+
+// Counts returns the requests made today, by subject and kind, so they can
+// be saved and given to Restore after a restart.
+func (l *Limiter) Counts() []Count {
+	day := l.Today()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counts := []Count{}
+	for k, b := range l.buckets {
+		if b.day == day && b.used > 0 {
+			counts = append(counts, Count{Kind: k.kind, Subject: k.subject, Used: b.used})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Subject != counts[j].Subject {
+			return counts[i].Subject < counts[j].Subject
+		}
+		return counts[i].Kind < counts[j].Kind
+	})
+	return counts
+}
+
+// nextDay returns the next midnight in the limiter's time zone.
+func (l *Limiter) nextDay(now time.Time) time.Time {
+	t := now.In(l.loc)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, l.loc)
+}
+
+// pruneLocked drops buckets that are full and from an earlier day, at most
+// once a minute. Caller must hold l.mu.
+func (l *Limiter) pruneLocked(now time.Time, day string) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for k, b := range l.buckets {
+		p := b.policy
+		full := p.PerMinute <= 0 || b.tokens+now.Sub(b.last).Seconds()*p.PerMinute/60 >= float64(burst(p))
+		if b.day != day && full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func burst(p Policy) int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return max(1, int(math.Ceil(p.PerMinute)))
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var taipei = time.FixedZone("Asia/Taipei", 8*60*60)
+
+// newTestLimiter returns a limiter on a clock the test moves by hand,
+// starting at now.
+func newTestLimiter(p Policy, now time.Time) (*Limiter, *time.Time) {
+	l := New(map[Kind]Policy{KindChat: p}, taipei)
+	clock := now
+	l.now = func() time.Time { return clock }
+	return l, &clock
+}
+
+func limitError(t *testing.T, err error) *Error {
+	t.Helper()
+	var le *Error
+	if !errors.As(err, &le) {
+		t.Fatalf("err = %v, want a limit error", err)
+	}
+	return le
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	l, clock := newTestLimiter(Policy{PerMinute: 6, Burst: 3}, time.Date(2026, 10, 19, 12, 0, 0, 0, taipei))
+
+	for i := range 3 {
+		if err := l.Allow(KindChat, "line:U1"); err != nil {
+			t.Fatalf("request %d of the burst: %v", i+1, err)
+		}
+	}
+	le := limitError(t, l.Allow(KindChat, "line:U1"))
+	if le.Daily || le.RetryAfter.Round(time.Millisecond) != 10*time.Second {
+		t.Errorf("error = %+v, want a rate limit for 10s", le)
+	}
+
+	// Six a minute is one every ten seconds.
+	*clock = clock.Add(9 * time.Second)
+	le = limitError(t, l.Allow(KindChat, "line:U1"))
+	if le.RetryAfter.Round(time.Millisecond) != time.Second {
+		t.Errorf("retry after %s, want 1s", le.RetryAfter)
+	}
+	*clock = clock.Add(time.Second)
+	if err := l.Allow(KindChat, "line:U1"); err != nil {
+		t.Errorf("after refilling one token: %v", err)
+	}
+	if err := l.Allow(KindChat, "line:U1"); err == nil {
+		t.Error("a second request went through on one token")
+	}
+
+	// The bucket never holds more than the burst.
+	*clock = clock.Add(time.Hour)
+	for range 3 {
+		l.Allow(KindChat, "line:U1")
+	}
+	if err := l.Allow(KindChat, "line:U1"); err == nil {
+		t.Error("the bucket refilled past its burst")
+	}
+
+	// Other subjects have their own bucket.
+	if err := l.Allow(KindChat, "line:U2"); err != nil {
+		t.Errorf("another user was limited: %v", err)
+	}
+}
+
+func TestDailyQuotaRollsOverAtMidnight(t *testing.T) {
+	// 23:59 in Taipei is 15:59 UTC; the quota's day is Taipei's.
+	l, clock := newTestLimiter(Policy{Daily: 2}, time.Date(2026, 10, 19, 15, 59, 0, 0, time.UTC))
+
+	for range 2 {
+		if err := l.Allow(KindChat, "line:U1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	le := limitError(t, l.Allow(KindChat, "line:U1"))
+	reset := time.Date(2026, 10, 20, 0, 0, 0, 0, taipei)
+	if !le.Daily || le.Limit != 2 || !le.ResetAt.Equal(reset) || le.RetryAfter != time.Minute {
+		t.Errorf("error = %+v, want the daily quota until %s", le, reset)
+	}
+	if used, daily := l.Usage(KindChat, "line:U1"); used != 2 || daily != 2 {
+		t.Errorf("usage = %d of %d", used, daily)
+	}
+
+	*clock = clock.Add(time.Minute)
+	if l.Today() != "2026-10-20" {
+		t.Fatalf("today = %s, want 2026-10-20", l.Today())
+	}
+	if used, _ := l.Usage(KindChat, "line:U1"); used != 0 {
+		t.Errorf("used %d on the new day", used)
+	}
+	if err := l.Allow(KindChat, "line:U1"); err != nil {
+		t.Errorf("first request of the new day: %v", err)
+	}
+}
+
+func TestCountsAndRestore(t *testing.T) {
+	p := Policy{Daily: 3}
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, taipei)
+	l, _ := newTestLimiter(p, start)
+	l.Allow(KindChat, "line:U1")
+	l.Allow(KindChat, "line:U1")
+	l.AllowPolicy(KindImage, "line:U1", Policy{Daily: 5})
+	l.Allow(KindChat, "line:U2")
+
+	counts := l.Counts()
+	want := []Count{{KindChat, "line:U1", 2}, {KindImage, "line:U1", 1}, {KindChat, "line:U2", 1}}
+	if len(counts) != len(want) {
+		t.Fatalf("counts = %+v, want %+v", counts, want)
+	}
+	for i := range want {
+		if counts[i] != want[i] {
+			t.Errorf("counts[%d] = %+v, want %+v", i, counts[i], want[i])
+		}
+	}
+
+	// After a restart the restored count still holds the quota.
+	restarted, clock := newTestLimiter(p, start.Add(time.Hour))
+	for _, c := range counts {
+		restarted.Restore(c.Kind, c.Subject, restarted.Policy(c.Kind), c.Used)
+	}
+	if err := restarted.Allow(KindChat, "line:U1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Allow(KindChat, "line:U1"); err == nil {
+		t.Error("the restored quota allowed a fourth request")
+	}
+
+	// Yesterday's counts aren't reported.
+	*clock = clock.Add(24 * time.Hour)
+	if counts := restarted.Counts(); len(counts) != 0 {
+		t.Errorf("counts on the next day = %+v", counts)
+	}
+}
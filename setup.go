@@ -0,0 +1,93 @@
+package main
+
+import (
+	"linebot-grok/config"
+	"linebot-grok/feedback"
+	"linebot-grok/gemini"
+	"linebot-grok/geo"
+	"linebot-grok/geocode"
+	"linebot-grok/grok"
+	"linebot-grok/models"
+	"linebot-grok/postback"
+	"linebot-grok/prompt"
+	"linebot-grok/provider"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"linebot-grok/utils"
+	"log"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// applyConfig sets up the packages and shared state from the loaded config.
+func applyConfig(cfg *config.Config) {
+	gemini.Configure(gemini.Options{
+		APIKey:  cfg.Gemini.APIKey,
+		BaseURL: cfg.Gemini.BaseURL,
+		Timeout: cfg.Gemini.Timeout,
+		Retry:   cfg.Gemini.Retry,
+		Breaker: cfg.Gemini.Breaker,
+	})
+	grok.Configure(grok.Options{
+		APIKey:  cfg.Grok.APIKey,
+		BaseURL: cfg.Grok.BaseURL,
+		Timeout: cfg.Grok.Timeout,
+		Retry:   cfg.Grok.Retry,
+		Breaker: cfg.Grok.Breaker,
+	})
+	geo.Configure(geo.Options{
+		DBPath:          cfg.GeoIP.DBPath,
+		PrivateLocation: cfg.GeoIP.PrivateLocation,
+	})
+	if err := utils.ConfigureTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
+	if err := geocode.Load(cfg.Geocode.CitiesFile); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Models != nil {
+		if err := models.Set(*cfg.Models); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := prompt.LoadDefault(cfg.Bot.SystemPromptFile); err != nil {
+		log.Fatal(err)
+	}
+
+	// Postback payloads are signed with the postback secret, or the channel secret if unset
+	postbackSecret := cfg.LINE.PostbackSecret
+	if postbackSecret == "" {
+		postbackSecret = cfg.LINE.ChannelSecret
+	}
+	signer = postback.NewSigner(postbackSecret)
+
+	// Validate has checked the time zone.
+	quotaZone, _ := time.LoadLocation(cfg.Limits.TimeZone)
+	limiter = ratelimit.New(map[ratelimit.Kind]ratelimit.Policy{
+		ratelimit.KindChat:   cfg.Limits.Chat,
+		ratelimit.KindSearch: cfg.Limits.Search,
+		ratelimit.KindImage:  cfg.Limits.Image,
+	}, quotaZone)
+	usageZone = quotaZone
+	pricing = cfg.Pricing
+	provider.OnUsage(recordUsage)
+	feedbackLog = feedback.New(cfg.Feedback.File)
+
+	c = cache.New(cfg.Cache.ContextTTL, 2*cfg.Cache.ContextTTL)
+	st = store.New(store.Options{
+		MaxLogMessages: cfg.GroupLog.MaxMessages,
+		MaxLogAge:      cfg.GroupLog.MaxAge,
+		ImageTTL:       cfg.Cache.ImageTTL,
+	})
+	day, counts, err := st.LoadQuotas(cfg.Limits.File)
+	if err != nil {
+		log.Fatal(err)
+	}
+	restoreQuotas(day, counts)
+	if err := st.LoadAPIKeys(cfg.Server.APIKeysFile); err != nil {
+		log.Fatal(err)
+	}
+	restoreKeyQuotas()
+}
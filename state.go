@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"log"
+	"strings"
+	"time"
+)
+
+// stateSaveInterval is how often the counts kept in memory are saved.
+const stateSaveInterval = 30 * time.Second
+
+// saveStateEvery calls saveState every interval until ctx is done. A failed
+// save is logged and tried again on the next tick.
+func saveStateEvery(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			saveState()
+		}
+	}
+}
+
+// saveState saves the use of API keys and the day's request counts.
+func saveState() {
+	flushKeyUse()
+	saveQuotas()
+}
+
+// saveQuotas saves the requests made today so a restart doesn't reset
+// daily quotas. API keys count theirs with the keys.
+func saveQuotas() {
+	counts := []store.QuotaCount{}
+	for _, c := range limiter.Counts() {
+		if strings.HasPrefix(c.Subject, "key:") {
+			continue
+		}
+		counts = append(counts, store.QuotaCount{Kind: string(c.Kind), Subject: c.Subject, Used: c.Used})
+	}
+	if err := st.SaveQuotas(limiter.Today(), counts); err != nil {
+		log.Printf("Error saving request counts: %v", err)
+	}
+}
+
+// restoreQuotas carries the request counts saved on day over to the
+// limiter, if day is still today.
+func restoreQuotas(day string, counts []store.QuotaCount) {
+	if day != limiter.Today() {
+		return
+	}
+	for _, c := range counts {
+		kind := ratelimit.Kind(c.Kind)
+		limiter.Restore(kind, c.Subject, limiter.Policy(kind), c.Used)
+	}
+}
@@ -0,0 +1,215 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// APIKey is a bearer key for the HTTP chat routes. Only the hash of the key
+// is kept; the key itself is shown once when it is issued.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+	// Scopes are the routes the key may call, e.g. "grok" and "gemini".
+	Scopes []string `json:"scopes"`
+	// PerMinute and Daily replace the default limits of the routes when set.
+	PerMinute float64 `json:"perMinute,omitempty"`
+	Daily     int     `json:"daily,omitempty"`
+	// CreatedBy is the LINE user who issued the key.
+	CreatedBy string    `json:"createdBy"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"lastUsed"`
+	// Day and Used count the requests of each kind made on Day, so daily
+	// quotas hold across restarts.
+	Day  string         `json:"day,omitempty"`
+	Used map[string]int `json:"used,omitempty"`
+}
+
+// keyUse is how much a key was used, kept apart from the key so recording
+// a request doesn't hold the store's lock.
+type keyUse struct {
+	Day      string
+	Used     map[string]int
+	LastUsed time.Time
+	// dirty is set until the use is saved to the keys file.
+	dirty bool
+}
+
+func (k APIKey) Allows(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// HashKey returns the stored hash of a key.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadAPIKeys reads the keys saved in path and saves them there from now
+// on. A missing file means no keys have been issued yet; an empty path
+// keeps keys in memory only.
+func (s *Store) LoadAPIKeys(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKeysFile = path
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys := []APIKey{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, k := range keys {
+		s.apiKeys[k.ID] = k
+		s.keyUse[k.ID] = keyUse{Day: k.Day, Used: k.Used, LastUsed: k.LastUsed}
+	}
+	return nil
+}
+
+// saveAPIKeysLocked rewrites the keys file. Caller must hold s.mu.
+func (s *Store) saveAPIKeysLocked() error {
+	if s.apiKeysFile == "" {
+		return nil
+	}
+	keys := s.apiKeysLocked()
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(s.apiKeysFile, data); err != nil {
+		return err
+	}
+	s.keyUseMu.Lock()
+	for _, k := range keys {
+		if u, ok := s.keyUse[k.ID]; ok && u.LastUsed.Equal(k.LastUsed) {
+			u.dirty = false
+			s.keyUse[k.ID] = u
+		}
+	}
+	s.keyUseMu.Unlock()
+	return nil
+}
+
+// apiKeysLocked returns the keys with their latest use, oldest first.
+// Caller must hold s.mu.
+func (s *Store) apiKeysLocked() []APIKey {
+	s.keyUseMu.Lock()
+	defer s.keyUseMu.Unlock()
+	keys := []APIKey{}
+	for _, k := range s.apiKeys {
+		if u, ok := s.keyUse[k.ID]; ok {
+			k.Day, k.Used, k.LastUsed = u.Day, maps.Clone(u.Used), u.LastUsed
+		}
+		keys = append(keys, k)
+	}
+	sortAPIKeys(keys)
+	return keys
+}
+
+func (s *Store) AddAPIKey(k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKeys[k.ID] = k
+	if err := s.saveAPIKeysLocked(); err != nil {
+		delete(s.apiKeys, k.ID)
+		return err
+	}
+	return nil
+}
+
+// APIKeyFor returns the key whose hash matches key.
+func (s *Store) APIKeyFor(key string) (APIKey, bool) {
+	hash := HashKey(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.apiKeys {
+		if k.Hash == hash {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// UseAPIKey records a request of kind made with the key with id on day.
+// Uses are kept in memory, apart from the other keys' state, and saved by
+// FlushAPIKeys, so requests don't wait for the keys file to be written.
+func (s *Store) UseAPIKey(id string, kind string, day string) {
+	s.keyUseMu.Lock()
+	defer s.keyUseMu.Unlock()
+	u := s.keyUse[id]
+	if u.Day != day || u.Used == nil {
+		u.Day, u.Used = day, map[string]int{}
+	}
+	u.Used[kind]++
+	u.LastUsed = time.Now()
+	u.dirty = true
+	s.keyUse[id] = u
+}
+
+// FlushAPIKeys saves the keys if any was used since they were last saved.
+func (s *Store) FlushAPIKeys() error {
+	s.keyUseMu.Lock()
+	dirty := false
+	for _, u := range s.keyUse {
+		dirty = dirty || u.dirty
+	}
+	s.keyUseMu.Unlock()
+	if !dirty {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveAPIKeysLocked()
+}
+
+// RevokeAPIKey deletes the key with id. It reports whether there was one.
+func (s *Store) RevokeAPIKey(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.apiKeys, id)
+	if err := s.saveAPIKeysLocked(); err != nil {
+		s.apiKeys[id] = k
+		return true, err
+	}
+	s.keyUseMu.Lock()
+	delete(s.keyUse, id)
+	s.keyUseMu.Unlock()
+	return true, nil
+}
+
+// APIKeys returns the issued keys, oldest first.
+func (s *Store) APIKeys() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.apiKeysLocked()
+}
+
+func sortAPIKeys(keys []APIKey) {
+	slices.SortFunc(keys, func(a, b APIKey) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+}
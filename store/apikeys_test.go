@@ -0,0 +1,75 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyUseIsSavedOnFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	s := New(Options{})
+	if err := s.LoadAPIKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAPIKey(APIKey{ID: "k1", Hash: HashKey("secret"), Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(path)
+
+	s.UseAPIKey("k1", "chat", "2026-10-19")
+	s.UseAPIKey("k1", "chat", "2026-10-19")
+	if data, _ := os.ReadFile(path); string(data) != string(saved) {
+		t.Error("using a key rewrote the keys file")
+	}
+	if k := s.APIKeys()[0]; k.Used["chat"] != 2 || k.LastUsed.IsZero() {
+		t.Errorf("key = %+v, want 2 chats", k)
+	}
+
+	if err := s.FlushAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := New(Options{})
+	if err := reloaded.LoadAPIKeys(path); err != nil {
+		t.Fatal(err)
+	}
+	if k := reloaded.APIKeys()[0]; k.Day != "2026-10-19" || k.Used["chat"] != 2 {
+		t.Errorf("reloaded key = %+v, want 2 chats on 2026-10-19", k)
+	}
+
+	// A new day starts the count again.
+	s.UseAPIKey("k1", "chat", "2026-10-20")
+	if k := s.APIKeys()[0]; k.Day != "2026-10-20" || k.Used["chat"] != 1 {
+		t.Errorf("key = %+v, want 1 chat on 2026-10-20", k)
+	}
+}
+
+func TestAPIKeyUseKeptWhenSaveFails(t *testing.T) {
+	dir := t.TempDir()
+	s := New(Options{})
+	if err := s.LoadAPIKeys(filepath.Join(dir, "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddAPIKey(APIKey{ID: "k1", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.apiKeysFile = filepath.Join(dir, "missing", "keys.json")
+	s.UseAPIKey("k1", "search", "2026-10-19")
+	if err := s.FlushAPIKeys(); err == nil {
+		t.Fatal("saving to a missing directory succeeded")
+	}
+
+	s.apiKeysFile = filepath.Join(dir, "keys.json")
+	if err := s.FlushAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := New(Options{})
+	if err := reloaded.LoadAPIKeys(filepath.Join(dir, "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	if k := reloaded.APIKeys()[0]; k.Used["search"] != 1 {
+		t.Errorf("reloaded key = %+v, want the use saved on the next flush", k)
+	}
+}
@@ -0,0 +1,54 @@
+package store
+
+import "time"
+
+// Exchange is a question/answer pair, a turn of a conversation. The latest
+// one is kept so quick reply actions like "regenerate" or "shorter" can
+// build on it.
+type Exchange struct {
+	// ID identifies the turn for regenerating and editing it.
+	ID       string
+	Question string
+	Answer   string
+	// UserID is who asked, so the exchange is deleted when they ask to be forgotten.
+	UserID string
+	// Location is where the asker was, if they shared it.
+	Location *Location
+	Model    string
+	Time     time.Time
+	// Rating is +1 or -1 once the user rated the answer.
+	Rating int
+}
+
+func (s *Store) SetLastExchange(ref string, ex Exchange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exchanges[ref] = ex
+}
+
+func (s *Store) LastExchange(ref string) (Exchange, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ex, ok := s.exchanges[ref]
+	return ex, ok
+}
+
+// RateLastExchange records a rating for the latest answer.
+func (s *Store) RateLastExchange(ref string, rating int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ex, ok := s.exchanges[ref]
+	if !ok {
+		return false
+	}
+	ex.Rating = rating
+	s.exchanges[ref] = ex
+	return true
+}
+
+// ClearHistory forgets the conversation's latest exchange.
+func (s *Store) ClearHistory(ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.exchanges, ref)
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// SaveExport keeps a conversation export for download and returns the key
+// it is served under. Exports expire like images.
+func (s *Store) SaveExport(ref string, text string) string {
+	key := fmt.Sprintf("%s.txt", uuid.New().String())
+	s.exports.SetDefault(key, text)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for _, k := range s.exportIndex[ref] {
+		if _, found := s.exports.Get(k); found {
+			keys = append(keys, k)
+		}
+	}
+	s.exportIndex[ref] = append(keys, key)
+	return key
+}
+
+func (s *Store) Export(key string) (string, bool) {
+	text, found := s.exports.Get(key)
+	if !found {
+		return "", false
+	}
+	return text.(string), true
+}
+
+// DeleteExports drops every export of ref.
+func (s *Store) DeleteExports(ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteExportsLocked(ref)
+}
+
+// deleteExportsLocked drops every export of ref. Caller must hold s.mu.
+func (s *Store) deleteExportsLocked(ref string) {
+	for _, key := range s.exportIndex[ref] {
+		s.exports.Delete(key)
+	}
+	delete(s.exportIndex, ref)
+}
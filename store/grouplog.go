@@ -0,0 +1,93 @@
+package store
+
+import "time"
+
+// ChatLine is a single text message recorded from a group chat.
+type ChatLine struct {
+	UserID string    `json:"userId"`
+	Name   string    `json:"name"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+type groupLog struct {
+	lines []ChatLine
+}
+
+// EnableGroupLog opts a group in (or out) of message logging.
+// Turning logging off drops everything recorded so far.
+func (s *Store) EnableGroupLog(key string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !enabled {
+		delete(s.groupLogs, key)
+		return
+	}
+	if _, ok := s.groupLogs[key]; !ok {
+		s.groupLogs[key] = &groupLog{}
+	}
+}
+
+func (s *Store) GroupLogEnabled(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.groupLogs[key]
+	return ok
+}
+
+// AppendGroupLog records a line if the group has opted in.
+func (s *Store) AppendGroupLog(key string, line ChatLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gl, ok := s.groupLogs[key]
+	if !ok {
+		return
+	}
+	gl.lines = append(gl.lines, line)
+	s.pruneLocked(gl, line.Time)
+}
+
+// RecentGroupLog returns up to n of the latest lines (n <= 0 means all)
+// that were sent after since (zero time means no limit), oldest first.
+func (s *Store) RecentGroupLog(key string, n int, since time.Time) []ChatLine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gl, ok := s.groupLogs[key]
+	if !ok {
+		return nil
+	}
+	s.pruneLocked(gl, time.Now())
+
+	lines := gl.lines
+	if !since.IsZero() {
+		start := len(lines)
+		for i, l := range lines {
+			if !l.Time.Before(since) {
+				start = i
+				break
+			}
+		}
+		lines = lines[start:]
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	out := make([]ChatLine, len(lines))
+	copy(out, lines)
+	return out
+}
+
+// pruneLocked applies the retention limits. Caller must hold s.mu.
+func (s *Store) pruneLocked(gl *groupLog, now time.Time) {
+	if s.MaxLogAge > 0 {
+		cutoff := now.Add(-s.MaxLogAge)
+		drop := 0
+		for drop < len(gl.lines) && gl.lines[drop].Time.Before(cutoff) {
+			drop++
+		}
+		gl.lines = gl.lines[drop:]
+	}
+	if s.MaxLogMessages > 0 && len(gl.lines) > s.MaxLogMessages {
+		gl.lines = gl.lines[len(gl.lines)-s.MaxLogMessages:]
+	}
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// SaveImage keeps generated image data and returns the key it is served
+// under. The image is deleted when any of refs, the conversation it was made
+// in and the user who asked for it, is purged.
+func (s *Store) SaveImage(data []byte, refs ...string) string {
+	key := fmt.Sprintf("%s.png", uuid.New().String())
+	s.images.SetDefault(key, data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ref := range refs {
+		// Forget keys that already expired so the index doesn't grow forever.
+		keys := []string{}
+		for _, k := range s.imageIndex[ref] {
+			if _, found := s.images.Get(k); found {
+				keys = append(keys, k)
+			}
+		}
+		s.imageIndex[ref] = append(keys, key)
+	}
+	return key
+}
+
+func (s *Store) Image(key string) ([]byte, bool) {
+	data, found := s.images.Get(key)
+	if !found {
+		return nil, false
+	}
+	return data.([]byte), true
+}
+
+// deleteImagesLocked drops every image generated for ref. Caller must hold s.mu.
+func (s *Store) deleteImagesLocked(ref string) {
+	for _, key := range s.imageIndex[ref] {
+		s.images.Delete(key)
+	}
+	delete(s.imageIndex, ref)
+}
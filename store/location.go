@@ -0,0 +1,49 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Location is where a user said they are, from a shared LINE location or
+// the set location command.
+type Location struct {
+	Lat         float64
+	Lng         float64
+	City        string
+	Country     string
+	CountryCode string
+	TimeZone    string
+	// Address is what LINE sent along with a shared location, if anything.
+	Address string
+	Time    time.Time
+}
+
+// String returns "City, Country", or the coordinates when no city is known.
+func (l Location) String() string {
+	if l.City != "" {
+		return l.City + ", " + l.Country
+	}
+	return fmt.Sprintf("%.4f, %.4f", l.Lat, l.Lng)
+}
+
+// SetLocation remembers the location of a user. userRef is the user's own
+// conversation ref ("user:Uxxx"), so purging that conversation forgets it.
+func (s *Store) SetLocation(userRef string, loc Location) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locations[userRef] = loc
+}
+
+func (s *Store) Location(userRef string) (Location, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.locations[userRef]
+	return loc, ok
+}
+
+func (s *Store) ClearLocation(userRef string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locations, userRef)
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// QuotaCount is how many requests of a kind a subject made on a day.
+type QuotaCount struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject"`
+	Used    int    `json:"used"`
+}
+
+type savedQuotas struct {
+	Day    string       `json:"day"`
+	Counts []QuotaCount `json:"counts"`
+}
+
+// LoadQuotas reads the request counts saved in path and saves them there
+// from now on. A missing file means nothing was counted yet; an empty path
+// keeps counts in memory only.
+func (s *Store) LoadQuotas(path string) (day string, counts []QuotaCount, err error) {
+	s.quotasMu.Lock()
+	defer s.quotasMu.Unlock()
+	s.quotasFile, s.savedQuotas = path, nil
+	if path == "" {
+		return "", nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var q savedQuotas
+	if err := json.Unmarshal(data, &q); err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return q.Day, q.Counts, nil
+}
+
+// SaveQuotas saves the request counts of day, unless they are what was
+// saved last.
+func (s *Store) SaveQuotas(day string, counts []QuotaCount) error {
+	s.quotasMu.Lock()
+	defer s.quotasMu.Unlock()
+	if s.quotasFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(savedQuotas{Day: day, Counts: counts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, s.savedQuotas) {
+		return nil
+	}
+	if err := writeFile(s.quotasFile, data); err != nil {
+		return err
+	}
+	s.savedQuotas = data
+	return nil
+}
@@ -0,0 +1,25 @@
+package store
+
+// Settings are per-conversation preferences changed through commands and buttons.
+type Settings struct {
+	ChatModel  string
+	ImageModel string
+	Persona    string
+	Lang       string
+}
+
+func (s *Store) Settings(ref string) Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings[ref]
+}
+
+// UpdateSettings applies fn to the conversation's settings and stores the result.
+func (s *Store) UpdateSettings(ref string, fn func(*Settings)) Settings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings := s.settings[ref]
+	fn(&settings)
+	s.settings[ref] = settings
+	return settings
+}
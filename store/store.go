@@ -0,0 +1,134 @@
+package store
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Options are the retention limits of a Store. Zero values use the defaults.
+type Options struct {
+	MaxLogMessages int
+	MaxLogAge      time.Duration
+	ImageTTL       time.Duration
+}
+
+// Store keeps per-conversation bot state in memory.
+// Keys are conversation refs such as "group:Cxxx", "room:Rxxx" or "user:Uxxx".
+type Store struct {
+	mu         sync.RWMutex
+	groupLogs  map[string]*groupLog
+	images     *cache.Cache
+	imageIndex map[string][]string
+	// exports are conversation exports waiting to be downloaded.
+	exports     *cache.Cache
+	exportIndex map[string][]string
+	exchanges   map[string]Exchange
+	settings    map[string]Settings
+	locations   map[string]Location
+	summaries   map[string]Summary
+	usage       map[UsageKey]UsageTotals
+	apiKeys     map[string]APIKey
+	// apiKeysFile is where API keys are saved; empty keeps them in memory.
+	apiKeysFile string
+	// keyUseMu guards keyUse, the requests made with each API key.
+	keyUseMu sync.Mutex
+	keyUse   map[string]keyUse
+	// quotasMu guards the file of the day's request counts and what was
+	// last saved to it.
+	quotasMu    sync.Mutex
+	quotasFile  string
+	savedQuotas []byte
+	// usageDay is the day usage was last added, to prune once a day.
+	usageDay string
+
+	// Retention limits for group chat logs.
+	MaxLogMessages int
+	MaxLogAge      time.Duration
+}
+
+func New(opts Options) *Store {
+	if opts.MaxLogMessages <= 0 {
+		opts.MaxLogMessages = 500
+	}
+	if opts.MaxLogAge <= 0 {
+		opts.MaxLogAge = 24 * time.Hour
+	}
+	if opts.ImageTTL <= 0 {
+		opts.ImageTTL = 180 * time.Minute
+	}
+	return &Store{
+		groupLogs:      map[string]*groupLog{},
+		images:         cache.New(opts.ImageTTL, opts.ImageTTL),
+		imageIndex:     map[string][]string{},
+		exports:        cache.New(opts.ImageTTL, opts.ImageTTL),
+		exportIndex:    map[string][]string{},
+		exchanges:      map[string]Exchange{},
+		settings:       map[string]Settings{},
+		locations:      map[string]Location{},
+		summaries:      map[string]Summary{},
+		usage:          map[UsageKey]UsageTotals{},
+		apiKeys:        map[string]APIKey{},
+		keyUse:         map[string]keyUse{},
+		MaxLogMessages: opts.MaxLogMessages,
+		MaxLogAge:      opts.MaxLogAge,
+	}
+}
+
+// writeFile replaces the file at path with data, so readers never see it
+// half written.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Purge removes everything stored for a conversation, e.g. when the bot is
+// unfollowed or removed from a group.
+func (s *Store) Purge(ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.groupLogs, ref)
+	delete(s.exchanges, ref)
+	delete(s.settings, ref)
+	delete(s.locations, ref)
+	delete(s.summaries, ref)
+	s.deleteImagesLocked(ref)
+	s.deleteExportsLocked(ref)
+}
+
+// ForgetUser removes what userID left in other conversations: their lines
+// in group logs, the latest exchanges they asked for, and the running
+// summaries and exports of conversations they took part in. Their own
+// conversation is removed with Purge.
+func (s *Store) ForgetUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ref, gl := range s.groupLogs {
+		n := len(gl.lines)
+		gl.lines = slices.DeleteFunc(gl.lines, func(l ChatLine) bool { return l.UserID == userID })
+		if len(gl.lines) < n {
+			delete(s.summaries, ref)
+			s.deleteExportsLocked(ref)
+		}
+	}
+	for ref, ex := range s.exchanges {
+		if ex.UserID == userID {
+			delete(s.exchanges, ref)
+			delete(s.summaries, ref)
+			s.deleteExportsLocked(ref)
+		}
+	}
+	for ref, sum := range s.summaries {
+		if slices.Contains(sum.UserIDs, userID) {
+			delete(s.summaries, ref)
+			s.deleteExportsLocked(ref)
+		}
+	}
+}
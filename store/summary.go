@@ -0,0 +1,34 @@
+package store
+
+import "time"
+
+// Summary is the running summary of the turns of a conversation that no
+// longer fit in the model's context.
+type Summary struct {
+	Text string
+	// Turns is how many turns have been folded into the summary.
+	Turns int
+	// UserIDs are who asked the folded turns, so the summary is deleted
+	// when one of them asks to be forgotten.
+	UserIDs []string
+	Updated time.Time
+}
+
+func (s *Store) Summary(ref string) (Summary, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sum, ok := s.summaries[ref]
+	return sum, ok
+}
+
+func (s *Store) SetSummary(ref string, sum Summary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summaries[ref] = sum
+}
+
+func (s *Store) ClearSummary(ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.summaries, ref)
+}
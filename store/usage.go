@@ -0,0 +1,90 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// usageRetention is how long daily usage is kept, so last year's month can
+// still be compared.
+const usageRetention = 13 * 30 * 24 * time.Hour
+
+// UsageKey identifies a row of daily usage.
+type UsageKey struct {
+	// Day is "2006-01-02" in the quota time zone.
+	Day string `json:"day"`
+	// Subject is who the call was made for, e.g. "line:Uxxx" or "key:1a2b3c4d".
+	Subject string `json:"subject,omitempty"`
+	// Ref is the conversation, empty for HTTP requests.
+	Ref     string `json:"ref,omitempty"`
+	Model   string `json:"model"`
+	Command string `json:"command,omitempty"`
+}
+
+// UsageTotals add up provider calls.
+type UsageTotals struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	Images           int     `json:"images"`
+	Cost             float64 `json:"cost"`
+}
+
+func (t *UsageTotals) Add(o UsageTotals) {
+	t.Calls += o.Calls
+	t.PromptTokens += o.PromptTokens
+	t.CompletionTokens += o.CompletionTokens
+	t.Images += o.Images
+	t.Cost += o.Cost
+}
+
+// Tokens returns prompt and completion tokens together.
+func (t UsageTotals) Tokens() int {
+	return t.PromptTokens + t.CompletionTokens
+}
+
+// AddUsage adds t to the row k. Rows older than the retention are dropped
+// when a new day starts.
+func (s *Store) AddUsage(k UsageKey, t UsageTotals) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k.Day != s.usageDay {
+		s.usageDay = k.Day
+		if day, err := time.Parse(time.DateOnly, k.Day); err == nil {
+			oldest := day.Add(-usageRetention).Format(time.DateOnly)
+			for old := range s.usage {
+				if old.Day < oldest {
+					delete(s.usage, old)
+				}
+			}
+		}
+	}
+	row := s.usage[k]
+	row.Add(t)
+	s.usage[k] = row
+}
+
+// Usage returns the rows whose day starts with period ("2006-01-02" for a
+// day, "2006-01" for a month) and for which keep returns true.
+func (s *Store) Usage(period string, keep func(UsageKey) bool) map[UsageKey]UsageTotals {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rows := map[UsageKey]UsageTotals{}
+	for k, t := range s.usage {
+		if strings.HasPrefix(k.Day, period) && (keep == nil || keep(k)) {
+			rows[k] = t
+		}
+	}
+	return rows
+}
+
+// DeleteUsage drops every row of subject.
+func (s *Store) DeleteUsage(subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.usage {
+		if k.Subject == subject {
+			delete(s.usage, k)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"linebot-grok/store"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSummaryWords bounds the running summary so it stays cheap to send.
+const maxSummaryWords = 200
+
+// maxSummaryTokens is enough for maxSummaryWords words, or as many CJK characters.
+const maxSummaryTokens = 1024
+
+// summaryLocks serializes summary updates per conversation, so turns folded
+// in by overlapping requests aren't lost.
+var summaryLocks sync.Map
+
+// conversationSummary returns the running summary of ref. It lives as long
+// as the turns in the context cache: the turns that expire with them are
+// never folded in, so after a pause neither is sent again.
+func conversationSummary(ref string) (store.Summary, bool) {
+	sum, ok := st.Summary(ref)
+	if !ok {
+		return sum, false
+	}
+	if _, found := c.Get(ref); !found {
+		st.ClearSummary(ref)
+		return store.Summary{}, false
+	}
+	return sum, true
+}
+
+// withSummary adds the conversation's running summary to the system prompt.
+func withSummary(system string, ref string) string {
+	sum, ok := conversationSummary(ref)
+	if !ok || sum.Text == "" {
+		return system
+	}
+	return strings.TrimSpace(system + "\n\nSummary of the earlier conversation, for context:\n" + sum.Text)
+}
+
+// foldIntoSummary compresses turns that were dropped from the history into
+// the conversation's running summary with the utility model.
+func foldIntoSummary(ctx context.Context, ref string, turns []store.Exchange) {
+	if len(turns) == 0 {
+		return
+	}
+	mu, _ := summaryLocks.LoadOrStore(ref, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	sum, _ := st.Summary(ref)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Update the running summary of a chat conversation with the earlier messages below. Keep who said what, facts, decisions, preferences and open questions; leave out small talk. Write in the language of the conversation, in at most %d words. Only output the summary.\n\n", maxSummaryWords))
+	if sum.Text != "" {
+		sb.WriteString("Current summary:\n" + sum.Text + "\n\n")
+	}
+	sb.WriteString("Earlier messages:\n")
+	userIDs := slices.Clone(sum.UserIDs)
+	for _, t := range turns {
+		sb.WriteString("user: " + t.Question + "\nassistant: " + t.Answer + "\n")
+		if t.UserID != "" && !slices.Contains(userIDs, t.UserID) {
+			userIDs = append(userIDs, t.UserID)
+		}
+	}
+
+	text, err := askUtility(withCommand(ctx, "summary"), "", sb.String(), maxSummaryTokens)
+	if err != nil {
+		log.Printf("Error summarizing %s: %v", ref, err)
+		return
+	}
+	st.SetSummary(ref, store.Summary{
+		Text:    strings.TrimSpace(text),
+		Turns:   sum.Turns + len(turns),
+		UserIDs: userIDs,
+		Updated: time.Now(),
+	})
+}
@@ -0,0 +1,89 @@
+// Package tokens estimates how many tokens text costs and trims chat
+// history to a token budget, without a provider-specific tokenizer.
+package tokens
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// MessageOverhead is what a chat message costs besides its text: the role
+// and the separators around it.
+const MessageOverhead = 4
+
+// Estimate returns roughly how many tokens text is. Chinese, Japanese and
+// Korean characters are about one token each; other text is about four bytes
+// per token. It errs on the high side for mixed text so budgets hold.
+func Estimate(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other += utf8.RuneLen(r)
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// Message returns the estimated tokens of one chat message.
+func Message(text string) int {
+	return MessageOverhead + Estimate(text)
+}
+
+// Split divides history into the part that fits in budget tokens and the
+// rest, where cost is the tokens of one message. History is made of turns of
+// turnSize messages (a question and its answer) and whole turns are dropped.
+// The first turn is kept when it fits, since it often sets up the
+// conversation, and after it the newest turns that fit; the turns in
+// between are dropped. Both parts keep the order of history.
+func Split[T any](history []T, turnSize int, budget int, cost func(T) int) (kept []T, dropped []T) {
+	if turnSize <= 0 {
+		turnSize = 1
+	}
+	turnCost := func(turn []T) int {
+		n := 0
+		for _, m := range turn {
+			n += cost(m)
+		}
+		return n
+	}
+	var turns [][]T
+	for start := 0; start < len(history); start += turnSize {
+		turns = append(turns, history[start:min(start+turnSize, len(history))])
+	}
+	if len(turns) == 0 {
+		return history, nil
+	}
+
+	keepFirst := false
+	if n := turnCost(turns[0]); n <= budget {
+		keepFirst = true
+		budget -= n
+	}
+	// Newest turns first, until one doesn't fit.
+	from := len(turns)
+	for from > 1 {
+		n := turnCost(turns[from-1])
+		if n > budget {
+			break
+		}
+		budget -= n
+		from--
+	}
+
+	kept = []T{}
+	if keepFirst {
+		kept = append(kept, turns[0]...)
+	} else {
+		dropped = append(dropped, turns[0]...)
+	}
+	for i, t := range turns[1:] {
+		if i+1 < from {
+			dropped = append(dropped, t...)
+		} else {
+			kept = append(kept, t...)
+		}
+	}
+	return kept, dropped
+}
@@ -0,0 +1,144 @@
+package tokens
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 2},
+		{"你好世界", 4},
+		{"ひらがなカタカナ漢字", 10},
+		{"안녕하세요", 5},
+		// The full-width comma isn't a CJK character: it's 3 bytes of other text.
+		{"你好，world", 2 + 2},
+		{"今天天气很好, let's go hiking", 6 + 5},
+		{strings.Repeat("中", 1000), 1000},
+		{strings.Repeat("word ", 200), 250},
+	} {
+		if got := Estimate(tc.text); got != tc.want {
+			t.Errorf("Estimate(%q) = %d, want %d", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestEstimateCountsCJKPerCharacter(t *testing.T) {
+	// Counted by bytes, each of these would be about three quarters of a token.
+	for _, r := range "中文字かなカナ한글" {
+		if got := Estimate(strings.Repeat(string(r), 100)); got != 100 {
+			t.Errorf("Estimate of 100 %q = %d, want 100", r, got)
+		}
+	}
+	if got, want := Message("中文"), MessageOverhead+2; got != want {
+		t.Errorf("Message(%q) = %d, want %d", "中文", got, want)
+	}
+}
+
+// conversation alternates long Chinese and English questions and answers.
+func conversation(turns int) []string {
+	var msgs []string
+	for i := range turns {
+		if i%2 == 0 {
+			msgs = append(msgs,
+				fmt.Sprintf("第%d個問題：請問台北明天的天氣如何？%s", i, strings.Repeat("我想去陽明山走走。", i%5+1)),
+				fmt.Sprintf("Answer %d: tomorrow in Taipei looks cloudy with a chance of rain. %s", i, strings.Repeat("Bring an umbrella. ", i%7+1)),
+			)
+		} else {
+			msgs = append(msgs,
+				fmt.Sprintf("Question %d: what should I pack for a day hike? %s", i, strings.Repeat("It might get cold. ", i%3+1)),
+				fmt.Sprintf("回答%d：建議帶水、外套和雨具。%s", i, strings.Repeat("山上天氣變化很快，請注意安全。", i%4+1)),
+			)
+		}
+	}
+	return msgs
+}
+
+func total(msgs []string) int {
+	n := 0
+	for _, m := range msgs {
+		n += Message(m)
+	}
+	return n
+}
+
+func TestSplitLongConversation(t *testing.T) {
+	history := conversation(60)
+	for _, budget := range []int{200, 500, 1000, 2500} {
+		kept, dropped := Split(history, 2, budget, Message)
+
+		if len(kept)+len(dropped) != len(history) || len(kept)%2 != 0 {
+			t.Fatalf("budget %d: split %d messages into %d and %d", budget, len(history), len(kept), len(dropped))
+		}
+		if n := total(kept); n > budget {
+			t.Errorf("budget %d: kept %d tokens", budget, n)
+		}
+		// The first turn is kept, then the newest ones.
+		if !slices.Equal(kept[:2], history[:2]) {
+			t.Errorf("budget %d: first turn dropped", budget)
+		}
+		newest := kept[2:]
+		if !slices.Equal(newest, history[len(history)-len(newest):]) {
+			t.Errorf("budget %d: kept turns other than the first and newest", budget)
+		}
+		// The newest turns fill the budget: the next older one doesn't fit.
+		if len(dropped) > 0 {
+			next := dropped[len(dropped)-2:]
+			if total(kept)+total(next) <= budget {
+				t.Errorf("budget %d: dropped a turn that fits", budget)
+			}
+		}
+		// Dropped turns come back in order, so they can be summarized.
+		if !slices.Equal(dropped, history[2:len(history)-len(newest)]) {
+			t.Errorf("budget %d: dropped turns are not the middle of history in order", budget)
+		}
+	}
+}
+
+func TestSplitKeepsEverythingThatFits(t *testing.T) {
+	history := conversation(10)
+	kept, dropped := Split(history, 2, total(history), Message)
+	if !slices.Equal(kept, history) || len(dropped) != 0 {
+		t.Errorf("kept %d of %d messages with budget for all", len(kept), len(history))
+	}
+}
+
+func TestSplitFirstTurnTooLong(t *testing.T) {
+	history := append([]string{strings.Repeat("很長的開場白。", 200), "OK."}, conversation(6)...)
+	budget := total(history[len(history)-4:])
+	kept, dropped := Split(history, 2, budget, Message)
+	if !slices.Equal(kept, history[len(history)-4:]) {
+		t.Errorf("kept %d messages, want the two newest turns", len(kept))
+	}
+	if !slices.Equal(dropped, history[:len(history)-4]) {
+		t.Errorf("dropped %d messages, want the rest in order", len(dropped))
+	}
+}
+
+func TestSplitNoBudget(t *testing.T) {
+	history := conversation(4)
+	for _, budget := range []int{0, -100} {
+		kept, dropped := Split(history, 2, budget, Message)
+		if len(kept) != 0 || !slices.Equal(dropped, history) {
+			t.Errorf("budget %d: kept %d messages", budget, len(kept))
+		}
+	}
+	if kept, dropped := Split([]string{}, 2, 100, Message); len(kept) != 0 || len(dropped) != 0 {
+		t.Errorf("split of no history gave %q and %q", kept, dropped)
+	}
+}
+
+func TestSplitUnevenTurn(t *testing.T) {
+	// A question without its answer yet is a turn of its own.
+	history := append(conversation(3), "最後一個問題？")
+	kept, _ := Split(history, 2, total(history[:2])+Message(history[len(history)-1]), Message)
+	if want := []string{history[0], history[1], history[len(history)-1]}; !slices.Equal(kept, want) {
+		t.Errorf("kept %q, want %q", kept, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"linebot-grok/models"
+	"linebot-grok/store"
+	"linebot-grok/tokens"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// errNotLatest is returned when a turn other than the latest is regenerated.
+var errNotLatest = errors.New("only the latest answer can be regenerated")
+
+// errUnknownTurn is returned for a turn ID that is no longer in the history.
+var errUnknownTurn = errors.New("turn is no longer in the history")
+
+// newTurnID returns a short ID users can type in "AI edit <id>".
+func newTurnID() string {
+	return hex.EncodeToString(randomBytes(3))
+}
+
+// contextTurns returns the turns of the conversation still in the context cache, oldest first.
+func contextTurns(ref string) []store.Exchange {
+	if turns, found := c.Get(ref); found {
+		return turns.([]store.Exchange)
+	}
+	return nil
+}
+
+// turnsBefore finds the turn with id and returns the turns before it. The
+// latest exchange is found even after the context cache expired.
+func turnsBefore(ref string, id string) ([]store.Exchange, store.Exchange, bool) {
+	turns := contextTurns(ref)
+	if i := slices.IndexFunc(turns, func(t store.Exchange) bool { return t.ID == id }); i >= 0 {
+		return turns[:i], turns[i], true
+	}
+	if ex, ok := st.LastExchange(ref); ok && ex.ID == id {
+		return nil, ex, true
+	}
+	return nil, store.Exchange{}, false
+}
+
+// answerTurn answers ex after the turns of prefix and makes it the latest
+// turn, dropping whatever followed prefix. Turns that no longer fit the
+// model's budget are folded into the running summary.
+func answerTurn(ctx context.Context, ref string, prefix []store.Exchange, ex store.Exchange, model string) (store.Exchange, error) {
+	ex.ID = newTurnID()
+	ex.Rating = 0
+	ex, err := generateAnswer(ctx, ref, prefix, ex, model)
+	if err != nil {
+		return ex, err
+	}
+	ex.Time = time.Now()
+
+	m := models.Get().Resolve(ex.Model, models.KindChat)
+	budget := m.ContextBudget() - tokens.Message(withSummary(systemPrompt(ref), ref))
+	turns, dropped := splitTurns(append(slices.Clip(prefix), ex), budget)
+	c.Set(ref, turns, cache.DefaultExpiration)
+	if len(dropped) > 0 {
+		go foldIntoSummary(context.WithoutCancel(ctx), ref, dropped)
+	}
+	return ex, nil
+}
+
+// splitTurns keeps the first turn and the newest turns that fit in budget
+// tokens, and returns the others separately.
+func splitTurns(turns []store.Exchange, budget int) (kept []store.Exchange, dropped []store.Exchange) {
+	return tokens.Split(turns, 1, budget, func(t store.Exchange) int {
+		return tokens.Message(t.Question) + tokens.Message(t.Answer)
+	})
+}
+
+// regenerateTurn answers the latest turn's question again, with model if
+// set, and replaces the turn with the new answer.
+func regenerateTurn(ctx context.Context, ref string, id string, model string) (store.Exchange, error) {
+	prefix, turn, ok := turnsBefore(ref, id)
+	if !ok {
+		return turn, errUnknownTurn
+	}
+	if last, ok := st.LastExchange(ref); !ok || last.ID != id {
+		return turn, errNotLatest
+	}
+	return answerTurn(ctx, ref, prefix, store.Exchange{
+		Question: turn.Question,
+		UserID:   turn.UserID,
+		Location: turn.Location,
+	}, model)
+}
+
+// editTurn forks the conversation before the turn with id and answers ex
+// there instead, dropping that turn and those after it.
+func editTurn(ctx context.Context, ref string, id string, ex store.Exchange) (store.Exchange, error) {
+	prefix, _, ok := turnsBefore(ref, id)
+	if !ok {
+		return ex, errUnknownTurn
+	}
+	return answerTurn(ctx, ref, prefix, ex, "")
+}
+
+// turnError explains the errors of regenerating and editing turns, and
+// friendlyError the rest.
+func turnError(ref string, err error) string {
+	switch {
+	case errors.Is(err, errNotLatest):
+		return fmt.Sprintf("Only the latest answer can be regenerated. Use %q to branch from an earlier question.", command("edit <id> <question>"))
+	case errors.Is(err, errUnknownTurn):
+		return fmt.Sprintf("I no longer remember that question. Send %q to see the ones I do.", command("history"))
+	}
+	return friendlyError(ref, err)
+}
+
+// historyCommand handles "AI history": the turns in context with their IDs.
+func historyCommand(ref string) string {
+	turns := contextTurns(ref)
+	if len(turns) == 0 {
+		return "There is no conversation to show yet."
+	}
+	var sb strings.Builder
+	for _, t := range turns {
+		sb.WriteString(fmt.Sprintf("%s %s (%s)\n", t.ID, truncateRunes(t.Question, 60), t.Model))
+	}
+	sb.WriteString(fmt.Sprintf("Send %q to ask one of these differently and continue from there.", command("edit <id> <question>")))
+	return sb.String()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"linebot-grok/grok"
+	"linebot-grok/models"
+	"linebot-grok/store"
+	"linebot-grok/tokens"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// longConversation alternates long Chinese and English turns.
+func longConversation(n int) []store.Exchange {
+	turns := []store.Exchange{}
+	for i := range n {
+		ex := store.Exchange{ID: fmt.Sprintf("t%02d", i)}
+		if i%2 == 0 {
+			ex.Question = fmt.Sprintf("第%d題：這家餐廳的招牌菜是什麼？%s", i, strings.Repeat("我們有六個人。", i%4+1))
+			ex.Answer = fmt.Sprintf("Turn %d: the braised pork rice is the one to try. %s", i, strings.Repeat("Book a table before noon. ", i%5+1))
+		} else {
+			ex.Question = fmt.Sprintf("Turn %d: is there anything vegetarian on the menu? %s", i, strings.Repeat("One of us doesn't eat meat. ", i%3+1))
+			ex.Answer = fmt.Sprintf("第%d題：有的，素食炒麵很受歡迎。%s", i, strings.Repeat("也可以請廚師少放油。", i%6+1))
+		}
+		turns = append(turns, ex)
+	}
+	return turns
+}
+
+func turnIDs(turns []store.Exchange) []string {
+	ids := []string{}
+	for _, t := range turns {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func TestSplitTurns(t *testing.T) {
+	turns := longConversation(40)
+	kept, dropped := splitTurns(turns, 1500)
+	if len(dropped) == 0 {
+		t.Fatal("nothing was dropped")
+	}
+	if kept[0].ID != turns[0].ID {
+		t.Errorf("first turn %s was dropped", turns[0].ID)
+	}
+	newest := kept[1:]
+	if !slices.Equal(turnIDs(newest), turnIDs(turns[len(turns)-len(newest):])) {
+		t.Errorf("kept %v, want the first and the newest turns", turnIDs(kept))
+	}
+	if !slices.Equal(turnIDs(dropped), turnIDs(turns[1:len(turns)-len(newest)])) {
+		t.Errorf("dropped %v, want the turns in between in order", turnIDs(dropped))
+	}
+	cost := 0
+	for _, t := range kept {
+		cost += tokens.Message(t.Question) + tokens.Message(t.Answer)
+	}
+	if cost > 1500 {
+		t.Errorf("kept %d tokens of a 1500 budget", cost)
+	}
+}
+
+// sentMessages returns the messages of the last Grok request.
+func sentMessages(t *testing.T, b *testBot) []*grok.GrokCompletionsMessage {
+	t.Helper()
+	// The running summary goes to Gemini in the background; skip it.
+	var last []byte
+	for _, r := range b.mock.Requests() {
+		if r.Provider == "grok" {
+			last = r.Body
+		}
+	}
+	if last == nil {
+		t.Fatal("nothing was sent to Grok")
+	}
+	var req grok.GrokCompletionsRequest
+	if err := json.Unmarshal(last, &req); err != nil {
+		t.Fatal(err)
+	}
+	return req.Messages
+}
+
+func TestCallGrokAPITrimsHistory(t *testing.T) {
+	b := newTestBot(t)
+	model, _ := models.Get().Lookup("grok")
+	model.ContextTokens = 2000
+	history := longConversation(50)
+	system := "你是一個友善的美食助理。Answer in the language of the question."
+	message := "最後一個問題：what time does it close tonight?"
+
+	if _, err := callGrokAPI(context.Background(), history, model, system, message); err != nil {
+		t.Fatal(err)
+	}
+	msgs := sentMessages(t, b)
+	if len(msgs) < 5 || len(msgs) >= 2+2*len(history) {
+		t.Fatalf("sent %d messages for %d turns", len(msgs), len(history))
+	}
+	if msgs[0].Role != "system" || msgs[0].Content != system {
+		t.Errorf("first message = %+v, want the system prompt", msgs[0])
+	}
+	if last := msgs[len(msgs)-1]; last.Role != "user" || last.Content != message {
+		t.Errorf("last message = %+v, want the new message", last)
+	}
+	if msgs[1].Content != history[0].Question || msgs[2].Content != history[0].Answer {
+		t.Error("the first turn was not sent")
+	}
+	newest := history[len(history)-1]
+	if msgs[len(msgs)-3].Content != newest.Question || msgs[len(msgs)-2].Content != newest.Answer {
+		t.Error("the newest turn was not sent")
+	}
+	sent := 0
+	for _, m := range msgs {
+		sent += tokens.Message(m.Content)
+	}
+	if sent > model.ContextTokens {
+		t.Errorf("sent %d tokens of a %d budget", sent, model.ContextTokens)
+	}
+}
+
+func TestCallGrokAPIKeepsPromptAndMessageOverBudget(t *testing.T) {
+	b := newTestBot(t)
+	model, _ := models.Get().Lookup("grok")
+	model.ContextTokens = 50
+	system := strings.Repeat("請用繁體中文回答。", 10)
+	message := strings.Repeat("這個問題比整個預算還長。", 10)
+
+	if _, err := callGrokAPI(context.Background(), longConversation(5), model, system, message); err != nil {
+		t.Fatal(err)
+	}
+	msgs := sentMessages(t, b)
+	if len(msgs) != 2 || msgs[0].Content != system || msgs[1].Content != message {
+		t.Errorf("sent %d messages, want just the system prompt and the new message", len(msgs))
+	}
+}
+
+func TestGeminiIsSentTrimmedHistory(t *testing.T) {
+	for _, name := range []string{"gemini", "gemini-flash"} {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBot(t)
+			model, _ := models.Get().Lookup(name)
+			model.ContextTokens = 2000
+			history := longConversation(50)
+			ex := store.Exchange{Question: "最後一個問題：what time does it close tonight?"}
+
+			if _, err := askModel(context.Background(), model, "Answer briefly.", history, ex); err != nil {
+				t.Fatal(err)
+			}
+			reqs := b.mock.Requests()
+			if len(reqs) == 0 || reqs[0].Provider != "gemini" {
+				t.Fatal("nothing was sent to Gemini")
+			}
+			var req struct {
+				Contents []struct {
+					Role  string `json:"role"`
+					Parts []struct {
+						Text string `json:"text"`
+					} `json:"parts"`
+				} `json:"contents"`
+			}
+			if err := json.Unmarshal(reqs[0].Body, &req); err != nil {
+				t.Fatal(err)
+			}
+			msgs := req.Contents
+			if len(msgs) < 5 || len(msgs) >= 1+2*len(history) {
+				t.Fatalf("sent %d contents for %d turns", len(msgs), len(history))
+			}
+			if msgs[0].Role != "user" || msgs[0].Parts[0].Text != history[0].Question || msgs[1].Role != "model" || msgs[1].Parts[0].Text != history[0].Answer {
+				t.Error("the first turn was not sent")
+			}
+			newest := history[len(history)-1]
+			if msgs[len(msgs)-3].Parts[0].Text != newest.Question || msgs[len(msgs)-2].Parts[0].Text != newest.Answer {
+				t.Error("the newest turn was not sent")
+			}
+			if last := msgs[len(msgs)-1]; last.Role != "user" || last.Parts[0].Text != ex.Question {
+				t.Errorf("last content = %+v, want the new question", last)
+			}
+		})
+	}
+}
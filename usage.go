@@ -0,0 +1,155 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"linebot-grok/provider"
+	"linebot-grok/ratelimit"
+	"linebot-grok/store"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pricing prices recorded usage; usageZone is where usage days start.
+var (
+	pricing   = provider.DefaultPricing
+	usageZone = time.UTC
+)
+
+// recordUsage adds a provider call to the usage of the caller in ctx.
+func recordUsage(ctx context.Context, u provider.Usage) {
+	c := callerFrom(ctx)
+	st.AddUsage(store.UsageKey{
+		Day:     time.Now().In(usageZone).Format(time.DateOnly),
+		Subject: c.Subject,
+		Ref:     c.Ref,
+		Model:   u.Model,
+		Command: c.Command,
+	}, store.UsageTotals{
+		Calls:            1,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Images:           u.Images,
+		Cost:             pricing.Cost(u),
+	})
+}
+
+// sumUsage adds up rows.
+func sumUsage(rows map[store.UsageKey]store.UsageTotals) store.UsageTotals {
+	var total store.UsageTotals
+	for _, t := range rows {
+		total.Add(t)
+	}
+	return total
+}
+
+func formatUsage(t store.UsageTotals) string {
+	return fmt.Sprintf("%d calls, %d tokens, %d images, $%.4f", t.Calls, t.Tokens(), t.Images, t.Cost)
+}
+
+// usageCommand handles "AI usage": the caller's usage today and this month,
+// the chat's usage this month in groups, and what is left of today's quotas.
+func usageCommand(ctx context.Context, ref string) string {
+	c := callerFrom(ctx)
+	if c.Subject == "" {
+		return "I can't tell who you are in this chat."
+	}
+	now := time.Now().In(usageZone)
+	mine := func(k store.UsageKey) bool { return k.Subject == c.Subject }
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Today: %s\n", formatUsage(sumUsage(st.Usage(now.Format(time.DateOnly), mine)))))
+	sb.WriteString(fmt.Sprintf("This month: %s\n", formatUsage(sumUsage(st.Usage(now.Format("2006-01"), mine)))))
+	if !strings.HasPrefix(ref, "user:") {
+		chat := func(k store.UsageKey) bool { return k.Ref == ref }
+		sb.WriteString(fmt.Sprintf("This chat this month: %s\n", formatUsage(sumUsage(st.Usage(now.Format("2006-01"), chat)))))
+	}
+	quotas := []string{}
+	for _, kind := range []ratelimit.Kind{ratelimit.KindChat, ratelimit.KindSearch, ratelimit.KindImage} {
+		used, daily := limiter.Usage(kind, c.Subject)
+		if daily > 0 {
+			quotas = append(quotas, fmt.Sprintf("%s %d/%d", kind, daily-used, daily))
+		}
+	}
+	if len(quotas) > 0 {
+		sb.WriteString("Left today: " + strings.Join(quotas, ", "))
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// usageGroups are the ways /admin/usage can break a month down.
+var usageGroups = map[string]func(store.UsageKey) string{
+	"user":    func(k store.UsageKey) string { return k.Subject },
+	"group":   func(k store.UsageKey) string { return k.Ref },
+	"model":   func(k store.UsageKey) string { return k.Model },
+	"command": func(k store.UsageKey) string { return k.Command },
+	"day":     func(k store.UsageKey) string { return k.Day },
+}
+
+// requireAdmin only lets requests with the admin bearer token through. The
+// route doesn't exist when no token is configured.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.Server.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Server.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminUsageRoute serves GET /admin/usage?month=2006-01&by=user|group|model|command|day,
+// the month's totals broken down by one key, most expensive first.
+func adminUsageRoute(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().In(usageZone).Format("2006-01")
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		http.Error(w, "month must be YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	by := cmp.Or(r.URL.Query().Get("by"), "model")
+	group, ok := usageGroups[by]
+	if !ok {
+		http.Error(w, "by must be user, group, model, command or day", http.StatusBadRequest)
+		return
+	}
+
+	rows := st.Usage(month, nil)
+	totals := map[string]store.UsageTotals{}
+	for k, t := range rows {
+		g := totals[group(k)]
+		g.Add(t)
+		totals[group(k)] = g
+	}
+	type row struct {
+		Key string `json:"key"`
+		store.UsageTotals
+	}
+	out := []row{}
+	for k, t := range totals {
+		out = append(out, row{Key: k, UsageTotals: t})
+	}
+	slices.SortFunc(out, func(a, b row) int {
+		return cmp.Or(cmp.Compare(b.Cost, a.Cost), cmp.Compare(a.Key, b.Key))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"month": month,
+		"by":    by,
+		"rows":  out,
+		"total": sumUsage(rows),
+	})
+}
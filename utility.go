@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"linebot-grok/gemini"
+	"linebot-grok/grok"
+	"linebot-grok/models"
+)
+
+const (
+	// maxRewriteTokens leaves room to shorten or translate a long answer.
+	maxRewriteTokens = 4096
+	// maxSumTokens leaves room for a "who said what" summary of a busy
+	// group, which takes about a token per character in CJK.
+	maxSumTokens = 2048
+)
+
+// askUtility sends a one-off prompt to the utility model, on whichever
+// provider the catalog puts it, and allows up to maxTokens tokens of output.
+func askUtility(ctx context.Context, system string, prompt string, maxTokens int) (string, error) {
+	m := models.Get().UtilityModel()
+	if m.Provider != models.ProviderGrok {
+		return gemini.GenerateText(ctx, m.ID, system, prompt, int32(maxTokens))
+	}
+	messages := []*grok.GrokCompletionsMessage{}
+	if system != "" {
+		messages = append(messages, &grok.GrokCompletionsMessage{Role: "system", Content: system})
+	}
+	resp, err := grok.Complete(ctx, &grok.GrokCompletionsRequest{
+		Model:     m.ID,
+		Messages:  append(messages, &grok.GrokCompletionsMessage{Role: "user", Content: prompt}),
+		MaxTokens: maxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// suggestFollowUps asks the utility model for questions the user may want
+// to ask after question was answered.
+func suggestFollowUps(ctx context.Context, question string, answer string) ([]string, error) {
+	m := models.Get().UtilityModel()
+	if m.Provider == models.ProviderGrok {
+		return grok.SuggestFollowUps(ctx, m.ID, question, answer, maxFollowUps)
+	}
+	return gemini.SuggestFollowUps(ctx, m.ID, question, answer, maxFollowUps)
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// DefaultTrustedProxies are trusted when none are configured: loopback and
+// private networks, where reverse proxies and load balancers usually live.
+var DefaultTrustedProxies = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+var (
+	proxiesMu      sync.RWMutex
+	trustedProxies = mustParsePrefixes(DefaultTrustedProxies)
+)
+
+// ConfigureTrustedProxies sets the CIDRs whose forwarding headers are believed.
+func ConfigureTrustedProxies(cidrs []string) error {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		return err
+	}
+	proxiesMu.Lock()
+	defer proxiesMu.Unlock()
+	trustedProxies = prefixes
+	return nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		// A bare address trusts just that host.
+		if addr, err := netip.ParseAddr(c); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", c, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func mustParsePrefixes(cidrs []string) []netip.Prefix {
+	p, err := parsePrefixes(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	proxiesMu.RLock()
+	defer proxiesMu.RUnlock()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClientIP returns the IP of the client that made r. Forwarding headers
+// are only believed when the connection comes from a trusted proxy, and are
+// walked right to left so entries added by the client itself are ignored.
+func GetClientIP(r *http.Request) string {
+	remote, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !isTrustedProxy(remote) {
+		return remote.String()
+	}
+
+	var hops []string
+	if fwd := r.Header.Values("Forwarded"); len(fwd) > 0 {
+		hops = forwardedFor(fwd)
+	} else if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, v := range xff {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	} else if real := r.Header.Get("X-Real-IP"); real != "" {
+		hops = []string{real}
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			// Malformed or obfuscated entry: we can't see past it, so the
+			// last proxy we trust is as close to the client as we get.
+			break
+		}
+		client = addr
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the for= values of RFC 7239 Forwarded headers, in order.
+func forwardedFor(values []string) []string {
+	hops := []string{}
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, value)
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseAddr parses an address as found in RemoteAddr and forwarding headers:
+// with or without port, quotes, IPv6 brackets and zone.
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone("").Unmap(), true
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetClientIP(t *testing.T) {
+	if err := ConfigureTrustedProxies(DefaultTrustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:   "no proxy",
+			remote: "203.0.113.9:51234",
+			want:   "203.0.113.9",
+		},
+		{
+			name:    "untrusted remote with spoofed XFF",
+			remote:  "203.0.113.9:51234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-IP": {"198.51.100.2"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "trusted proxy",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "walks right to left across trusted hops",
+			remote:  "127.0.0.1:8080",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.66, 203.0.113.9, 192.168.1.1, 10.0.0.5"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "hops across several XFF headers",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.66, 203.0.113.9", "172.16.0.1"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "XFF without spaces",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.66,203.0.113.9,10.1.1.1"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "every hop trusted",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"X-Forwarded-For": {"192.168.1.20, 10.0.0.5"}},
+			want:    "192.168.1.20",
+		},
+		{
+			name:    "X-Real-IP",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"X-Real-IP": {"203.0.113.9"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "Forwarded with IPv6 and port",
+			remote:  "[::1]:443",
+			headers: map[string][]string{"Forwarded": {`for="[2001:db8::1]:4711";proto=https`}},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "Forwarded wins over XFF",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.9;by=10.0.0.2, for=10.0.0.7"}, "X-Forwarded-For": {"198.51.100.1"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "Forwarded for=unknown stops the walk",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.9, for=unknown, for=10.0.0.7"}},
+			want:    "10.0.0.7",
+		},
+		{
+			name:    "Forwarded obfuscated identifier",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"Forwarded": {`for="_hidden"`}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "malformed XFF entry",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9, not-an-ip"}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "empty XFF entry",
+			remote:  "10.0.0.2:443",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9,,"}},
+			want:    "10.0.0.2",
+		},
+		{
+			name:   "IPv4-mapped IPv6 remote",
+			remote: "[::ffff:203.0.113.9]:51234",
+			want:   "203.0.113.9",
+		},
+		{
+			name:    "IPv4-mapped IPv6 proxy is trusted",
+			remote:  "[::ffff:10.0.0.2]:443",
+			headers: map[string][]string{"X-Forwarded-For": {"::ffff:198.51.100.1"}},
+			want:    "198.51.100.1",
+		},
+		{
+			name:   "IPv6 remote with zone",
+			remote: "[fe80::1%eth0]:443",
+			want:   "fe80::1",
+		},
+		{
+			name:   "garbage RemoteAddr",
+			remote: "garbage",
+			want:   "",
+		},
+		{
+			name:    "empty RemoteAddr ignores headers",
+			remote:  "",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9"}},
+			want:    "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remote
+			for name, values := range tc.headers {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+			if got := GetClientIP(r); got != tc.want {
+				t.Errorf("GetClientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigureTrustedProxies(t *testing.T) {
+	defer ConfigureTrustedProxies(DefaultTrustedProxies)
+
+	if err := ConfigureTrustedProxies([]string{"203.0.113.0/24", " 2001:db8::1 ", ""}); err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"203.0.113.200": true,
+		"2001:db8::1":   true,
+		"2001:db8::2":   false,
+		"10.0.0.1":      false,
+	} {
+		a, ok := parseAddr(addr)
+		if !ok {
+			t.Fatalf("parseAddr(%q) failed", addr)
+		}
+		if got := isTrustedProxy(a); got != want {
+			t.Errorf("isTrustedProxy(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	if err := ConfigureTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("ConfigureTrustedProxies accepted an invalid CIDR")
+	}
+}